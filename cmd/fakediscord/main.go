@@ -0,0 +1,42 @@
+// fakediscord serves the discordtest fake discord api so steno can run offline
+//
+//	go run ./cmd/fakediscord -fixtures fixtures.json
+//	STENO_DISCORD_API=http://127.0.0.1:{port} STENO_STORE=memory go run .
+//
+// without -fixtures the discordtest default fixtures are served
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+
+	"steno/discord/discordtest"
+)
+
+func main() {
+	fixturesPath := flag.String("fixtures", "", "json fixtures file, see discordtest.Fixtures")
+	flag.Parse()
+
+	fixtures := discordtest.DefaultFixtures()
+	if *fixturesPath != "" {
+		f, err := os.Open(*fixturesPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fixtures, err = discordtest.FixturesFromReader(f)
+		f.Close()
+		if err != nil {
+			log.Fatalf("invalid fixtures %s", err)
+		}
+	}
+
+	srv := discordtest.ServerNew(fixtures)
+	defer srv.Close()
+	log.Printf("fake discord api listening on %s", srv.URL)
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	<-sig
+}
@@ -0,0 +1,142 @@
+package main
+
+// steno commands sync
+// registers applicationCommands with discord, commands that changed are
+// updated and commands steno no longer declares are deleted
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+
+	"steno/discord"
+)
+
+func commandsUsage() {
+	fmt.Fprintln(os.Stderr, `usage: steno commands sync [-app id] [-token token] [-guild id] [-dry-run]
+
+sync registers steno's slash and context menu commands with discord, globally
+or for a single guild when -guild is set. guild commands update instantly,
+global commands can take up to an hour to show up
+
+the application id and bot token default to STENO_DISCORD_APP_ID and STENO_DISCORD_TOKEN`)
+}
+
+// run `steno commands ...`, returns the exit code
+func commandsMain(args []string) int {
+	if len(args) == 0 || args[0] != "sync" {
+		commandsUsage()
+		return 2
+	}
+
+	flags := flag.NewFlagSet("commands sync", flag.ContinueOnError)
+	flags.Usage = commandsUsage
+	appID := flags.String("app", os.Getenv("STENO_DISCORD_APP_ID"), "application id")
+	token := flags.String("token", os.Getenv("STENO_DISCORD_TOKEN"), "bot token")
+	guildID := flags.String("guild", "", "sync the guild's commands instead of the global ones")
+	dryRun := flags.Bool("dry-run", false, "print the changes without making them")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if *appID == "" || *token == "" {
+		fmt.Fprintln(os.Stderr, "steno: an application id and bot token are required")
+		commandsUsage()
+		return 2
+	}
+
+	app, err := discord.ParseSnowflake(*appID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "steno: bad application id, %s\n", err)
+		return 2
+	}
+	// zero syncs the global commands
+	var guild discord.Snowflake
+	if *guildID != "" {
+		guild, err = discord.ParseSnowflake(*guildID)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "steno: bad guild id, %s\n", err)
+			return 2
+		}
+	}
+
+	auth := botAuthorization(*token)
+	client := discord.ClientNew()
+	if base := os.Getenv("STENO_DISCORD_API"); base != "" {
+		client.BaseURL = base
+	}
+
+	// ctrl-c stops waiting on discord instead of leaving a request hanging
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	err = syncCommands(ctx, os.Stdout, client, auth, app, guild, *dryRun)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "steno: sync failed, %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+func commandLabel(cmd discord.ApplicationCommand) string {
+	switch cmd.Type {
+	case discord.CommandUser:
+		return fmt.Sprintf("user command %q", cmd.Name)
+	case discord.CommandMessage:
+		return fmt.Sprintf("message command %q", cmd.Name)
+	}
+	return fmt.Sprintf("/%s", cmd.Name)
+}
+
+// make the registered commands match applicationCommands, the changes are
+// written to out
+func syncCommands(ctx context.Context, out io.Writer, client *discord.Client, auth string, appID, guildID discord.Snowflake, dryRun bool) error {
+	registered, err := client.ApplicationCommands(ctx, auth, appID, guildID)
+	if err != nil {
+		return fmt.Errorf("listing commands failed/%s", err)
+	}
+
+	diff := discord.DiffCommands(applicationCommands, registered)
+	scope := "global"
+	if guildID != 0 {
+		scope = "guild " + guildID.String()
+	}
+	fmt.Fprintf(out, "%s: %d to create, %d to update, %d to delete, %d unchanged\n",
+		scope, len(diff.Create), len(diff.Update), len(diff.Delete), len(diff.Unchanged))
+
+	for _, cmd := range diff.Create {
+		fmt.Fprintf(out, "create %s\n", commandLabel(cmd))
+		if !dryRun {
+			if _, err := client.CreateApplicationCommand(ctx, auth, appID, guildID, cmd); err != nil {
+				return fmt.Errorf("creating %s failed/%s", commandLabel(cmd), err)
+			}
+		}
+	}
+	for _, cmd := range diff.Update {
+		fmt.Fprintf(out, "update %s\n", commandLabel(cmd))
+		if !dryRun {
+			commandID, err := discord.ParseSnowflake(cmd.ID)
+			if err != nil {
+				return fmt.Errorf("updating %s failed/%s", commandLabel(cmd), err)
+			}
+			if _, err := client.EditApplicationCommand(ctx, auth, appID, guildID, commandID, cmd); err != nil {
+				return fmt.Errorf("updating %s failed/%s", commandLabel(cmd), err)
+			}
+		}
+	}
+	for _, cmd := range diff.Delete {
+		fmt.Fprintf(out, "delete %s\n", commandLabel(cmd))
+		if !dryRun {
+			commandID, err := discord.ParseSnowflake(cmd.ID)
+			if err != nil {
+				return fmt.Errorf("deleting %s failed/%s", commandLabel(cmd), err)
+			}
+			if err := client.DeleteApplicationCommand(ctx, auth, appID, guildID, commandID); err != nil {
+				return fmt.Errorf("deleting %s failed/%s", commandLabel(cmd), err)
+			}
+		}
+	}
+	return nil
+}
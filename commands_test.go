@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"steno/discord"
+	"steno/discord/discordtest"
+)
+
+func TestSyncCommands(t *testing.T) {
+	fixtures := discordtest.DefaultFixtures()
+	quote := applicationCommands[0]
+	quote.ID, quote.Description = "900000000000000001", "An old description"
+	fixtures.Commands = map[string][]discord.ApplicationCommand{
+		discordtest.BotID + "/" + discordtest.GuildID: {
+			quote,
+			{ID: "900000000000000002", Type: discord.CommandChatInput, Name: "old", Description: "Gone"},
+		},
+	}
+	srv := discordtest.ServerNew(fixtures)
+	defer srv.Close()
+	client := discord.ClientNew()
+	client.BaseURL = srv.URL
+
+	ctx := context.Background()
+	app, guild := snowflake(discordtest.BotID), snowflake(discordtest.GuildID)
+	before := srv.Commands(discordtest.BotID, discordtest.GuildID)
+
+	var out bytes.Buffer
+	if err := syncCommands(ctx, &out, client, discordtest.BotToken, app, guild, true); err != nil {
+		t.Fatal(err)
+	}
+	want := "guild " + discordtest.GuildID + ": 1 to create, 1 to update, 1 to delete, 0 unchanged\n" +
+		"create message command \"Save as quote\"\n" +
+		"update /quote\n" +
+		"delete /old\n"
+	if out.String() != want {
+		t.Errorf("dry run: got\n%s\nwant\n%s", out.String(), want)
+	}
+	if after := srv.Commands(discordtest.BotID, discordtest.GuildID); len(after) != len(before) ||
+		after[0].Description != before[0].Description || after[1].Name != before[1].Name {
+		t.Errorf("dry run changed the registered commands to %v", after)
+	}
+
+	out.Reset()
+	if err := syncCommands(ctx, &out, client, discordtest.BotToken, app, guild, false); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != want {
+		t.Errorf("sync: got\n%s\nwant\n%s", out.String(), want)
+	}
+
+	// everything is registered as declared now
+	out.Reset()
+	if err := syncCommands(ctx, &out, client, discordtest.BotToken, app, guild, true); err != nil {
+		t.Fatal(err)
+	}
+	want = "guild " + discordtest.GuildID + ": 0 to create, 0 to update, 0 to delete, 2 unchanged\n"
+	if out.String() != want {
+		t.Errorf("after sync: got %q, want %q", out.String(), want)
+	}
+}
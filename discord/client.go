@@ -0,0 +1,361 @@
+package discord
+
+// rest client for the discord api
+// requests wait out the rate limits discord reports in the X-RateLimit-*
+// headers, routes share a bucket when discord says they do and every
+// token gets its own buckets since limits are per user
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const BaseURL = "https://discord.com/api/v8"
+
+// APIError is an error response from discord
+type APIError struct {
+	// http status of the response
+	Status int `json:"-"`
+	// discord's json error code, 0 if the body wasn't a discord error
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	// per field errors of invalid form bodies
+	Errors json.RawMessage `json:"errors,omitempty"`
+	// seconds to wait before retrying a rate limited request
+	RetryAfter float64 `json:"retry_after,omitempty"`
+	Global     bool    `json:"global,omitempty"`
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("discord: %d %s", e.Status, http.StatusText(e.Status))
+	}
+	return fmt.Sprintf("discord: %d %s (code %d)", e.Status, e.Message, e.Code)
+}
+
+type bucket struct {
+	remaining int
+	reset     time.Time
+}
+
+// Client makes requests to the discord api, it is safe for concurrent use
+type Client struct {
+	BaseURL string
+	HTTP    *http.Client
+	// how many times a rate limited request, or a 5xx of an idempotent one, is retried
+	MaxRetries int
+	// the longest a request waits on a rate limit before giving up with the 429
+	MaxWait time.Duration
+
+	mu      sync.Mutex
+	routes  map[string]string  // route -> discord's bucket hash
+	buckets map[string]*bucket // bucket hash or route -> limit state
+	global  time.Time          // no requests until then
+}
+
+func ClientNew() *Client {
+	return &Client{
+		BaseURL:    BaseURL,
+		HTTP:       &http.Client{Timeout: 10 * time.Second},
+		MaxRetries: 3,
+		MaxWait:    10 * time.Second,
+		routes:     make(map[string]string),
+		buckets:    make(map[string]*bucket),
+	}
+}
+
+var snowflakeSegment = regexp.MustCompile(`/[0-9]{15,20}`)
+var majorParameter = regexp.MustCompile(`^/(channels|guilds|webhooks)/[0-9]+`)
+
+// the rate limit route of a request, ids other than the major parameter
+// are dropped since discord limits them together
+func routeKey(auth, method, endpoint string) string {
+	major := majorParameter.FindString(endpoint)
+	rest := snowflakeSegment.ReplaceAllString(strings.TrimPrefix(endpoint, major), "/:id")
+
+	sum := sha256.Sum256([]byte(auth))
+	return hex.EncodeToString(sum[:8]) + " " + method + " " + major + rest
+}
+
+// the key of the bucket the route was last seen in
+// caller must hold c.mu
+func (c *Client) bucketKey(route string) string {
+	hash, ok := c.routes[route]
+	if !ok {
+		return route
+	}
+	// routes in the same bucket still have separate limits per major parameter
+	parts := strings.SplitN(route, " ", 3)
+	return parts[0] + " " + hash + " " + majorParameter.FindString(parts[2])
+}
+
+// block until a request on route is allowed and take a request from its bucket
+func (c *Client) wait(ctx context.Context, route string) error {
+	for {
+		c.mu.Lock()
+		now := time.Now()
+		until := c.global
+		b := c.buckets[c.bucketKey(route)]
+		if b != nil && b.remaining <= 0 && b.reset.After(until) {
+			until = b.reset
+		}
+		if !until.After(now) {
+			if b != nil {
+				if b.reset.Before(now) {
+					// the window reset, we don't know the new limit until a response
+					delete(c.buckets, c.bucketKey(route))
+				} else {
+					b.remaining--
+				}
+			}
+			c.mu.Unlock()
+			return nil
+		}
+		c.mu.Unlock()
+
+		// give up now rather than wait past the caller's deadline
+		deadline, ok := ctx.Deadline()
+		if d := until.Sub(now); d > c.MaxWait || ok && until.After(deadline) {
+			rateLimitWaits.Inc("gave_up")
+			return &APIError{
+				Status:     http.StatusTooManyRequests,
+				Message:    "rate limited",
+				RetryAfter: d.Seconds(),
+			}
+		}
+		rateLimitWaits.Inc("waited")
+		if err := sleep(ctx, until.Sub(now)); err != nil {
+			return err
+		}
+	}
+}
+
+// like time.Sleep but returns ctx's error if it is done first
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// record the limits discord sent with a response
+func (c *Client) update(route string, h http.Header) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if hash := h.Get("X-RateLimit-Bucket"); hash != "" {
+		c.routes[route] = hash
+	}
+
+	remaining, err := strconv.Atoi(h.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+	resetAfter, err := strconv.ParseFloat(h.Get("X-RateLimit-Reset-After"), 64)
+	if err != nil {
+		return
+	}
+	c.buckets[c.bucketKey(route)] = &bucket{
+		remaining: remaining,
+		reset:     time.Now().Add(seconds(resetAfter)),
+	}
+}
+
+// back off from a 429, global limits block every route
+func (c *Client) limited(route string, apiErr *APIError, h http.Header) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	reset := time.Now().Add(seconds(apiErr.RetryAfter))
+	if apiErr.Global || h.Get("X-RateLimit-Global") != "" {
+		rateLimited.Inc("global")
+		c.global = reset
+		return
+	}
+	rateLimited.Inc("route")
+	c.buckets[c.bucketKey(route)] = &bucket{remaining: 0, reset: reset}
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// make a single attempt, the body is always read and closed
+func (c *Client) do(ctx context.Context, method, endpoint, auth string, body []byte) (*http.Response, []byte, error) {
+	u, err := url.Parse(c.BaseURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	u.Path = path.Join(u.Path, endpoint)
+
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), reqBody)
+	if err != nil {
+		return nil, nil, err
+	}
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	out, err := ioutil.ReadAll(resp.Body)
+	return resp, out, err
+}
+
+// methods that leave discord in the same state however many times they're sent
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+func decodeError(status int, body []byte) *APIError {
+	apiErr := &APIError{}
+	if json.Unmarshal(body, apiErr) != nil {
+		apiErr = &APIError{}
+	}
+	apiErr.Status = status
+	return apiErr
+}
+
+// Do requests endpoint, relative to the client's base url, with auth as the
+// Authorization header. in is encoded as the json body if it isn't nil and
+// the response is decoded into out if it isn't nil. error responses are
+// returned as *APIError. 429s are retried for every method, 5xxs only for
+// idempotent ones. ctx cancels the request, including any time spent
+// waiting on rate limits or between retries
+func (c *Client) Do(ctx context.Context, method, endpoint, auth string, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		body, err = json.Marshal(in)
+		if err != nil {
+			return err
+		}
+	}
+
+	route := routeKey(auth, method, endpoint)
+	label := metricRoute(endpoint)
+	for attempt := 0; ; attempt++ {
+		err := c.wait(ctx, route)
+		if err != nil {
+			return err
+		}
+
+		start := time.Now()
+		resp, respBody, err := c.do(ctx, method, endpoint, auth, body)
+		requestDuration.Since(start, method, label)
+		if err != nil {
+			requestsTotal.Inc(method, label, "error")
+			return err
+		}
+		requestsTotal.Inc(method, label, strconv.Itoa(resp.StatusCode))
+		c.update(route, resp.Header)
+
+		switch {
+		case resp.StatusCode == http.StatusTooManyRequests:
+			apiErr := decodeError(resp.StatusCode, respBody)
+			if apiErr.RetryAfter == 0 {
+				apiErr.RetryAfter, _ = strconv.ParseFloat(resp.Header.Get("Retry-After"), 64)
+			}
+			c.limited(route, apiErr, resp.Header)
+			if attempt >= c.MaxRetries || seconds(apiErr.RetryAfter) > c.MaxWait {
+				return apiErr
+			}
+			// wait picks up the new reset time
+
+		case resp.StatusCode >= 500:
+			// discord may have applied a POST or PATCH before failing, only
+			// requests that are safe to repeat are retried
+			if attempt >= c.MaxRetries || !idempotent(method) {
+				return decodeError(resp.StatusCode, respBody)
+			}
+			// 250ms, 500ms, 1s ...
+			err := sleep(ctx, time.Duration(250*math.Pow(2, float64(attempt)))*time.Millisecond)
+			if err != nil {
+				return err
+			}
+
+		case resp.StatusCode >= 400:
+			return decodeError(resp.StatusCode, respBody)
+
+		default:
+			if out == nil || len(respBody) == 0 {
+				return nil
+			}
+			return json.Unmarshal(respBody, out)
+		}
+	}
+}
+
+// CurrentUser is GET /users/@me
+func (c *Client) CurrentUser(ctx context.Context, auth string) (User, error) {
+	var user User
+	err := c.Do(ctx, http.MethodGet, "/users/@me", auth, nil, &user)
+	return user, err
+}
+
+// CurrentUserGuilds is GET /users/@me/guilds, the partial guilds include
+// the user's permissions
+func (c *Client) CurrentUserGuilds(ctx context.Context, auth string) ([]Guild, error) {
+	var guilds []Guild
+	err := c.Do(ctx, http.MethodGet, "/users/@me/guilds", auth, nil, &guilds)
+	return guilds, err
+}
+
+// Guild is GET /guilds/{guild.id}
+func (c *Client) Guild(ctx context.Context, auth string, guildID Snowflake) (Guild, error) {
+	var guild Guild
+	err := c.Do(ctx, http.MethodGet, path.Join("/guilds", guildID.String()), auth, nil, &guild)
+	return guild, err
+}
+
+// GuildMember is GET /guilds/{guild.id}/members/{user.id}
+func (c *Client) GuildMember(ctx context.Context, auth string, guildID, userID Snowflake) (GuildMember, error) {
+	var member GuildMember
+	err := c.Do(ctx, http.MethodGet, path.Join("/guilds", guildID.String(), "members", userID.String()), auth, nil, &member)
+	return member, err
+}
+
+// Gateway is GET /gateway, the websocket url bots connect to. it doesn't
+// need a token so it doubles as a check that discord can be reached
+func (c *Client) Gateway(ctx context.Context) (string, error) {
+	var gateway struct {
+		URL string `json:"url"`
+	}
+	err := c.Do(ctx, http.MethodGet, "/gateway", "", nil, &gateway)
+	return gateway.URL, err
+}
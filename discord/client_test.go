@@ -0,0 +1,72 @@
+package discord
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// a server that answers the first failures requests with status then 200
+func failingServer(t *testing.T, status int, failures int32) (*Client, *int32) {
+	t.Helper()
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&requests, 1)
+		w.Header().Set("Content-Type", "application/json")
+		if n <= failures {
+			if status == http.StatusTooManyRequests {
+				w.Header().Set("Retry-After", "0.01")
+				w.WriteHeader(status)
+				w.Write([]byte(`{"message": "You are being rate limited.", "retry_after": 0.01, "global": false}`))
+				return
+			}
+			w.WriteHeader(status)
+			w.Write([]byte(`{"message": "Internal Server Error", "code": 0}`))
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	c := ClientNew()
+	c.BaseURL = srv.URL
+	return c, &requests
+}
+
+func TestDoRetries(t *testing.T) {
+	tests := []struct {
+		method   string
+		status   int
+		wantErr  int
+		requests int32
+	}{
+		{http.MethodGet, 500, 0, 2},
+		{http.MethodPut, 502, 0, 2},
+		{http.MethodDelete, 503, 0, 2},
+		{http.MethodHead, 500, 0, 2},
+		// discord may have already applied these
+		{http.MethodPost, 500, 500, 1},
+		{http.MethodPatch, 502, 502, 1},
+		// rate limited requests were never processed
+		{http.MethodPost, 429, 0, 2},
+		{http.MethodPatch, 429, 0, 2},
+	}
+	for _, tt := range tests {
+		c, requests := failingServer(t, tt.status, 1)
+		err := c.Do(context.Background(), tt.method, "/channels/1/messages", "Bot token", nil, nil)
+
+		var apiErr *APIError
+		switch {
+		case tt.wantErr == 0 && err != nil:
+			t.Errorf("%s after a %d: got %v, want success", tt.method, tt.status, err)
+		case tt.wantErr != 0 && (!errors.As(err, &apiErr) || apiErr.Status != tt.wantErr):
+			t.Errorf("%s after a %d: got %v, want a %d APIError", tt.method, tt.status, err, tt.wantErr)
+		}
+		if n := atomic.LoadInt32(requests); n != tt.requests {
+			t.Errorf("%s after a %d: got %d requests, want %d", tt.method, tt.status, n, tt.requests)
+		}
+	}
+}
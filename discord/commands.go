@@ -0,0 +1,123 @@
+package discord
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"path"
+	"sort"
+)
+
+// the commands endpoint of an application, guild commands if guildID isn't zero
+func commandsPath(appID, guildID Snowflake) string {
+	if guildID == 0 {
+		return path.Join("/applications", appID.String(), "commands")
+	}
+	return path.Join("/applications", appID.String(), "guilds", guildID.String(), "commands")
+}
+
+// ApplicationCommands is GET /applications/{application.id}/commands, or the
+// guild's commands if guildID isn't zero
+func (c *Client) ApplicationCommands(ctx context.Context, auth string, appID, guildID Snowflake) ([]ApplicationCommand, error) {
+	var commands []ApplicationCommand
+	err := c.Do(ctx, http.MethodGet, commandsPath(appID, guildID), auth, nil, &commands)
+	return commands, err
+}
+
+// CreateApplicationCommand is POST /applications/{application.id}/commands,
+// creating a command with an existing name overwrites it
+func (c *Client) CreateApplicationCommand(ctx context.Context, auth string, appID, guildID Snowflake, cmd ApplicationCommand) (ApplicationCommand, error) {
+	var out ApplicationCommand
+	err := c.Do(ctx, http.MethodPost, commandsPath(appID, guildID), auth, commandBody(cmd), &out)
+	return out, err
+}
+
+// EditApplicationCommand is PATCH /applications/{application.id}/commands/{command.id}
+func (c *Client) EditApplicationCommand(ctx context.Context, auth string, appID, guildID, commandID Snowflake, cmd ApplicationCommand) (ApplicationCommand, error) {
+	var out ApplicationCommand
+	err := c.Do(ctx, http.MethodPatch, path.Join(commandsPath(appID, guildID), commandID.String()), auth, commandBody(cmd), &out)
+	return out, err
+}
+
+// DeleteApplicationCommand is DELETE /applications/{application.id}/commands/{command.id}
+func (c *Client) DeleteApplicationCommand(ctx context.Context, auth string, appID, guildID, commandID Snowflake) error {
+	return c.Do(ctx, http.MethodDelete, path.Join(commandsPath(appID, guildID), commandID.String()), auth, nil, nil)
+}
+
+// the writable fields of a command
+func commandBody(cmd ApplicationCommand) ApplicationCommand {
+	return ApplicationCommand{
+		Type:        cmd.Type,
+		Name:        cmd.Name,
+		Description: cmd.Description,
+		Options:     cmd.Options,
+	}
+}
+
+// commands are unique by type and name within an application
+type commandKey struct {
+	Type ApplicationCommandType
+	Name string
+}
+
+func keyOf(cmd ApplicationCommand) commandKey {
+	t := cmd.Type
+	if t == 0 {
+		t = CommandChatInput
+	}
+	return commandKey{Type: t, Name: cmd.Name}
+}
+
+// compare the writable fields, discord fills in defaults so compare the
+// json each side would send
+func sameCommand(a, b ApplicationCommand) bool {
+	a, b = commandBody(a), commandBody(b)
+	a.Type, b.Type = keyOf(a).Type, keyOf(b).Type
+	aj, errA := json.Marshal(a)
+	bj, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(aj) == string(bj)
+}
+
+// CommandDiff is what has to change for the registered commands to match the declared ones
+type CommandDiff struct {
+	// declared commands that aren't registered
+	Create []ApplicationCommand
+	// declared commands that differ from what is registered, with the registered command's id
+	Update []ApplicationCommand
+	// registered commands that aren't declared
+	Delete []ApplicationCommand
+	// declared commands that are already registered as declared
+	Unchanged []ApplicationCommand
+}
+
+// DiffCommands matches declared commands to registered ones by type and name
+func DiffCommands(declared, registered []ApplicationCommand) CommandDiff {
+	byKey := make(map[commandKey]ApplicationCommand, len(registered))
+	for _, cmd := range registered {
+		byKey[keyOf(cmd)] = cmd
+	}
+
+	var diff CommandDiff
+	for _, cmd := range declared {
+		reg, ok := byKey[keyOf(cmd)]
+		switch {
+		case !ok:
+			diff.Create = append(diff.Create, cmd)
+		case sameCommand(cmd, reg):
+			diff.Unchanged = append(diff.Unchanged, reg)
+		default:
+			cmd.ID = reg.ID
+			diff.Update = append(diff.Update, cmd)
+		}
+		delete(byKey, keyOf(cmd))
+	}
+
+	for _, cmd := range byKey {
+		diff.Delete = append(diff.Delete, cmd)
+	}
+	// map order is random, keep the output stable
+	sort.Slice(diff.Delete, func(i, j int) bool {
+		return diff.Delete[i].Name < diff.Delete[j].Name
+	})
+	return diff
+}
@@ -0,0 +1,87 @@
+package discord
+
+import (
+	"testing"
+)
+
+func names(cmds []ApplicationCommand) []string {
+	out := make([]string, len(cmds))
+	for i, cmd := range cmds {
+		out[i] = cmd.Name
+	}
+	return out
+}
+
+func equalNames(got, want []string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestDiffCommands(t *testing.T) {
+	quote := ApplicationCommand{
+		Type:        CommandChatInput,
+		Name:        "quote",
+		Description: "Save and recall quotes",
+		Options: []ApplicationCommandOption{
+			{Type: OptionString, Name: "text", Description: "What they said", Required: true},
+		},
+	}
+	save := ApplicationCommand{Type: CommandMessage, Name: "Save as quote"}
+
+	// registered commands come back with ids and discord's defaults filled in
+	registered := func(cmd ApplicationCommand, id string) ApplicationCommand {
+		cmd.ID, cmd.ApplicationID = id, "300000000000000001"
+		return cmd
+	}
+	changed := quote
+	changed.Options = []ApplicationCommandOption{
+		{Type: OptionString, Name: "text", Description: "What they said"},
+	}
+	untyped := registered(quote, "1")
+	untyped.Type = 0
+
+	tests := []struct {
+		name       string
+		declared   []ApplicationCommand
+		registered []ApplicationCommand
+		create     []string
+		update     []string
+		delete     []string
+		unchanged  []string
+	}{
+		{"unchanged", []ApplicationCommand{quote, save},
+			[]ApplicationCommand{registered(quote, "1"), registered(save, "2")}, nil, nil, nil, []string{"quote", "Save as quote"}},
+		{"missing type is a slash command", []ApplicationCommand{quote},
+			[]ApplicationCommand{untyped}, nil, nil, nil, []string{"quote"}},
+		{"changed option", []ApplicationCommand{changed},
+			[]ApplicationCommand{registered(quote, "1")}, nil, []string{"quote"}, nil, nil},
+		{"added", []ApplicationCommand{quote, save},
+			[]ApplicationCommand{registered(quote, "1")}, []string{"Save as quote"}, nil, nil, []string{"quote"}},
+		{"removed", []ApplicationCommand{quote},
+			[]ApplicationCommand{registered(quote, "1"), registered(save, "2")}, nil, nil, []string{"Save as quote"}, []string{"quote"}},
+		{"same name other type", []ApplicationCommand{{Type: CommandMessage, Name: "quote"}},
+			[]ApplicationCommand{registered(quote, "1")}, []string{"quote"}, nil, []string{"quote"}, nil},
+	}
+	for _, tt := range tests {
+		diff := DiffCommands(tt.declared, tt.registered)
+		if !equalNames(names(diff.Create), tt.create) || !equalNames(names(diff.Update), tt.update) ||
+			!equalNames(names(diff.Delete), tt.delete) || !equalNames(names(diff.Unchanged), tt.unchanged) {
+			t.Errorf("%s: got create %v update %v delete %v unchanged %v, want %v %v %v %v", tt.name,
+				names(diff.Create), names(diff.Update), names(diff.Delete), names(diff.Unchanged),
+				tt.create, tt.update, tt.delete, tt.unchanged)
+		}
+		// updates are sent to the registered command
+		for _, cmd := range diff.Update {
+			if cmd.ID != "1" {
+				t.Errorf("%s: got update of %q, want the registered id 1", tt.name, cmd.ID)
+			}
+		}
+	}
+}
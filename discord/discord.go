@@ -224,6 +224,27 @@ type GuildMember struct {
 	Permissions string `json:"permissions"`
 }
 
+type Message struct {
+	// id of the message
+	ID string `json:"id"`
+	// id of the channel the message was sent in
+	ChannelID string `json:"channel_id"`
+	// id of the guild the message was sent in
+	GuildID string `json:"guild_id"`
+	// the author of this message
+	Author User `json:"author"`
+	// contents of the message
+	Content string `json:"content"`
+	// when this message was sent
+	Timestamp string `json:"timestamp"` //  ISO8601 timestamp
+	// when this message was edited (or null if never)
+	EditedTimestamp string `json:"edited_timestamp"` //  ISO8601 timestamp
+	// any embedded content
+	Embeds []Embed `json:"embeds"`
+	// type of message
+	Type int `json:"type"`
+}
+
 type Emoji struct {
 	// emoji id
 	ID string
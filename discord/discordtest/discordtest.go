@@ -0,0 +1,399 @@
+// Package discordtest is a fake discord api for running steno offline,
+// it serves the endpoints steno uses from fixtures
+//
+//	srv := discordtest.ServerNew(discordtest.DefaultFixtures())
+//	defer srv.Close()
+//	client := discord.ClientNew()
+//	client.BaseURL = srv.URL
+package discordtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/julienschmidt/httprouter"
+
+	"steno/discord"
+)
+
+// Fixtures is the state of the fake api, tokens are full Authorization
+// headers e.g. "Bearer abc" or "Bot abc"
+type Fixtures struct {
+	// token -> the user it belongs to
+	Users map[string]discord.User `json:"users"`
+	// token -> the partial guilds of /users/@me/guilds
+	UserGuilds map[string][]discord.Guild `json:"user_guilds"`
+	// guild id -> the full guild including roles
+	Guilds map[string]discord.Guild `json:"guilds"`
+	// guild id -> user id -> member
+	Members map[string]map[string]discord.GuildMember `json:"members"`
+	// application id for global commands or "{application id}/{guild id}"
+	// for guild commands -> the registered commands
+	Commands map[string][]discord.ApplicationCommand `json:"commands"`
+}
+
+// FixturesFromReader decodes json fixtures
+func FixturesFromReader(r io.Reader) (Fixtures, error) {
+	var f Fixtures
+	err := json.NewDecoder(r).Decode(&f)
+	return f, err
+}
+
+// Tokens and ids of DefaultFixtures
+const (
+	GuildID = "100000000000000001"
+
+	OwnerToken = "Bearer owner-token"
+	OwnerID    = "200000000000000001"
+
+	ModToken = "Bearer mod-token"
+	ModID    = "200000000000000002"
+
+	MemberToken = "Bearer member-token"
+	MemberID    = "200000000000000003"
+
+	// a user that isn't in GuildID
+	OutsiderToken = "Bearer outsider-token"
+	OutsiderID    = "200000000000000004"
+
+	BotToken = "Bot bot-token"
+	BotID    = "300000000000000001"
+
+	// a bot in GuildID that isn't BotID
+	OtherBotToken = "Bot other-bot-token"
+	OtherBotID    = "300000000000000002"
+
+	ModRoleID = "400000000000000001"
+)
+
+// DefaultFixtures is a guild with an owner, a moderator with
+// MANAGE_MESSAGES, a plain member, two bots and a user from outside the guild
+func DefaultFixtures() Fixtures {
+	user := func(id, name string, bot bool) discord.User {
+		return discord.User{ID: id, Username: name, Discriminator: "0001", Bot: bot}
+	}
+	owner := user(OwnerID, "owner", false)
+	mod := user(ModID, "mod", false)
+	member := user(MemberID, "member", false)
+	outsider := user(OutsiderID, "outsider", false)
+	bot := user(BotID, "steno", true)
+	otherBot := user(OtherBotID, "other", true)
+
+	everyone := fmt.Sprint(uint64(discord.PermissionViewChannel | discord.PermissionSendMessages))
+	modPerms := fmt.Sprint(uint64(discord.PermissionManageMessages))
+	guild := discord.Guild{
+		ID:      GuildID,
+		Name:    "steno test guild",
+		OwnerID: OwnerID,
+		Roles: []discord.Role{
+			{ID: GuildID, Name: "@everyone", Permissions: everyone},
+			{ID: ModRoleID, Name: "mod", Permissions: modPerms},
+		},
+	}
+	partial := func(perms string, owner bool) []discord.Guild {
+		return []discord.Guild{{ID: GuildID, Name: guild.Name, Owner: owner, Permissions: perms}}
+	}
+	allPerms := fmt.Sprint(uint64(discord.PermissionAll))
+	modGuildPerms := fmt.Sprint(uint64(discord.PermissionViewChannel |
+		discord.PermissionSendMessages | discord.PermissionManageMessages))
+
+	return Fixtures{
+		Users: map[string]discord.User{
+			OwnerToken:    owner,
+			ModToken:      mod,
+			MemberToken:   member,
+			OutsiderToken: outsider,
+			BotToken:      bot,
+			OtherBotToken: otherBot,
+		},
+		UserGuilds: map[string][]discord.Guild{
+			OwnerToken:    partial(allPerms, true),
+			ModToken:      partial(modGuildPerms, false),
+			MemberToken:   partial(everyone, false),
+			OutsiderToken: {},
+			BotToken:      partial(everyone, false),
+			OtherBotToken: partial(everyone, false),
+		},
+		Guilds: map[string]discord.Guild{GuildID: guild},
+		Members: map[string]map[string]discord.GuildMember{
+			GuildID: {
+				OwnerID:    {User: owner},
+				ModID:      {User: mod, Roles: []string{ModRoleID}},
+				MemberID:   {User: member},
+				BotID:      {User: bot},
+				OtherBotID: {User: otherBot},
+			},
+		},
+	}
+}
+
+// Server is a running fake discord api, URL is the base url to give the client
+type Server struct {
+	*httptest.Server
+
+	mu       sync.RWMutex
+	fixtures Fixtures
+	requests map[string]int
+	nextID   int
+}
+
+func ServerNew(fixtures Fixtures) *Server {
+	s := &Server{fixtures: fixtures, requests: make(map[string]int)}
+
+	router := httprouter.New()
+	router.GET("/gateway", s.gateway)
+	router.GET("/users/@me", s.currentUser)
+	router.GET("/users/@me/guilds", s.currentUserGuilds)
+	router.GET("/guilds/:guild_id", s.guild)
+	router.GET("/guilds/:guild_id/members/:user_id", s.guildMember)
+	router.GET("/applications/:app_id/commands", s.commands)
+	router.POST("/applications/:app_id/commands", s.createCommand)
+	router.PATCH("/applications/:app_id/commands/:command_id", s.editCommand)
+	router.DELETE("/applications/:app_id/commands/:command_id", s.deleteCommand)
+	router.GET("/applications/:app_id/guilds/:guild_id/commands", s.commands)
+	router.POST("/applications/:app_id/guilds/:guild_id/commands", s.createCommand)
+	router.PATCH("/applications/:app_id/guilds/:guild_id/commands/:command_id", s.editCommand)
+	router.DELETE("/applications/:app_id/guilds/:guild_id/commands/:command_id", s.deleteCommand)
+	router.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, 0, "404: Not Found")
+	})
+
+	s.Server = httptest.NewServer(router)
+	return s
+}
+
+// SetFixtures replaces the fixtures the server answers with
+func (s *Server) SetFixtures(fixtures Fixtures) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fixtures = fixtures
+}
+
+// Requests returns how many times "METHOD /path" was requested
+func (s *Server) Requests(route string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.requests[route]
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// errors in the same shape as discord's
+func writeError(w http.ResponseWriter, status, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"code": code, "message": message})
+}
+
+// count the request and resolve its token, writes a 401 if the token is unknown
+func (s *Server) user(w http.ResponseWriter, r *http.Request) (discord.User, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests[r.Method+" "+r.URL.Path]++
+	user, ok := s.fixtures.Users[r.Header.Get("Authorization")]
+	if !ok {
+		writeError(w, http.StatusUnauthorized, 0, "401: Unauthorized")
+	}
+	return user, ok
+}
+
+// GatewayURL is the url /gateway answers with
+const GatewayURL = "wss://gateway.discord.gg"
+
+// /gateway doesn't need a token
+func (s *Server) gateway(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	s.mu.Lock()
+	s.requests[r.Method+" "+r.URL.Path]++
+	s.mu.Unlock()
+	writeJSON(w, map[string]string{"url": GatewayURL})
+}
+
+func (s *Server) currentUser(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	user, ok := s.user(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, user)
+}
+
+func (s *Server) currentUserGuilds(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if _, ok := s.user(w, r); !ok {
+		return
+	}
+
+	s.mu.RLock()
+	guilds := s.fixtures.UserGuilds[r.Header.Get("Authorization")]
+	s.mu.RUnlock()
+	if guilds == nil {
+		guilds = []discord.Guild{}
+	}
+	writeJSON(w, guilds)
+}
+
+// the token has to be in the guild to see it
+func (s *Server) inGuild(user discord.User, guildID string) bool {
+	_, ok := s.fixtures.Members[guildID][user.ID]
+	return ok
+}
+
+func (s *Server) guild(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	user, ok := s.user(w, r)
+	if !ok {
+		return
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	guild, ok := s.fixtures.Guilds[ps.ByName("guild_id")]
+	if !ok || !s.inGuild(user, guild.ID) {
+		writeError(w, http.StatusNotFound, 10004, "Unknown Guild")
+		return
+	}
+	writeJSON(w, guild)
+}
+
+func (s *Server) guildMember(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	user, ok := s.user(w, r)
+	if !ok {
+		return
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	guildID := ps.ByName("guild_id")
+	if _, ok := s.fixtures.Guilds[guildID]; !ok || !s.inGuild(user, guildID) {
+		writeError(w, http.StatusNotFound, 10004, "Unknown Guild")
+		return
+	}
+	member, ok := s.fixtures.Members[guildID][ps.ByName("user_id")]
+	if !ok {
+		writeError(w, http.StatusNotFound, 10007, "Unknown Member")
+		return
+	}
+	writeJSON(w, member)
+}
+
+// Commands returns the registered commands, guildID is empty for global commands
+func (s *Server) Commands(appID, guildID string) []discord.ApplicationCommand {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]discord.ApplicationCommand(nil), s.fixtures.Commands[commandsKey(appID, guildID)]...)
+}
+
+func commandsKey(appID, guildID string) string {
+	if guildID == "" {
+		return appID
+	}
+	return appID + "/" + guildID
+}
+
+func (s *Server) commands(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if _, ok := s.user(w, r); !ok {
+		return
+	}
+
+	commands := s.Commands(ps.ByName("app_id"), ps.ByName("guild_id"))
+	if commands == nil {
+		commands = []discord.ApplicationCommand{}
+	}
+	writeJSON(w, commands)
+}
+
+// decode a command body, writes a 400 if it isn't one
+func readCommand(w http.ResponseWriter, r *http.Request) (discord.ApplicationCommand, bool) {
+	var cmd discord.ApplicationCommand
+	err := json.NewDecoder(r.Body).Decode(&cmd)
+	if err != nil || cmd.Name == "" {
+		writeError(w, http.StatusBadRequest, 50035, "Invalid Form Body")
+		return cmd, false
+	}
+	if cmd.Type == 0 {
+		cmd.Type = discord.CommandChatInput
+	}
+	return cmd, true
+}
+
+func (s *Server) createCommand(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if _, ok := s.user(w, r); !ok {
+		return
+	}
+	cmd, ok := readCommand(w, r)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fixtures.Commands == nil {
+		s.fixtures.Commands = make(map[string][]discord.ApplicationCommand)
+	}
+	key := commandsKey(ps.ByName("app_id"), ps.ByName("guild_id"))
+	cmd.ApplicationID = ps.ByName("app_id")
+	cmd.GuildID = ps.ByName("guild_id")
+
+	// like discord, creating a command with a taken name overwrites it
+	for i, existing := range s.fixtures.Commands[key] {
+		if existing.Name == cmd.Name && existing.Type == cmd.Type {
+			cmd.ID = existing.ID
+			s.fixtures.Commands[key][i] = cmd
+			writeJSON(w, cmd)
+			return
+		}
+	}
+
+	s.nextID++
+	cmd.ID = fmt.Sprintf("9%017d", s.nextID)
+	s.fixtures.Commands[key] = append(s.fixtures.Commands[key], cmd)
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, cmd)
+}
+
+func (s *Server) editCommand(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if _, ok := s.user(w, r); !ok {
+		return
+	}
+	cmd, ok := readCommand(w, r)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := commandsKey(ps.ByName("app_id"), ps.ByName("guild_id"))
+	for i, existing := range s.fixtures.Commands[key] {
+		if existing.ID == ps.ByName("command_id") {
+			cmd.ID, cmd.ApplicationID, cmd.GuildID = existing.ID, existing.ApplicationID, existing.GuildID
+			s.fixtures.Commands[key][i] = cmd
+			writeJSON(w, cmd)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, 10063, "Unknown application command")
+}
+
+func (s *Server) deleteCommand(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if _, ok := s.user(w, r); !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := commandsKey(ps.ByName("app_id"), ps.ByName("guild_id"))
+	commands := s.fixtures.Commands[key]
+	for i, existing := range commands {
+		if existing.ID == ps.ByName("command_id") {
+			s.fixtures.Commands[key] = append(commands[:i:i], commands[i+1:]...)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, 10063, "Unknown application command")
+}
@@ -0,0 +1,307 @@
+package discord
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+type InteractionType int
+
+const (
+	InteractionPing InteractionType = iota + 1
+	InteractionApplicationCommand
+	InteractionMessageComponent
+)
+
+type Interaction struct {
+	// id of the interaction
+	ID string `json:"id"`
+	// id of the application this interaction is for
+	ApplicationID string `json:"application_id"`
+	// the type of interaction
+	Type InteractionType `json:"type"`
+	// the command data payload
+	Data *ApplicationCommandInteractionData `json:"data,omitempty"`
+	// the guild it was sent from
+	GuildID string `json:"guild_id,omitempty"`
+	// the channel it was sent from
+	ChannelID string `json:"channel_id,omitempty"`
+	// guild member data for the invoking user, including permissions
+	Member *GuildMember `json:"member,omitempty"`
+	// user object for the invoking user, if invoked in a DM
+	User *User `json:"user,omitempty"`
+	// a continuation token for responding to the interaction
+	Token string `json:"token"`
+	// read-only property, always 1
+	Version int `json:"version"`
+}
+
+// Invoker returns the user that triggered the interaction in a guild or a DM
+func (i Interaction) Invoker() User {
+	if i.Member != nil {
+		return i.Member.User
+	}
+	if i.User != nil {
+		return *i.User
+	}
+	return User{}
+}
+
+type ApplicationCommandInteractionData struct {
+	// the ID of the invoked command
+	ID string `json:"id"`
+	// the name of the invoked command
+	Name string `json:"name"`
+	// the type of the invoked command
+	Type ApplicationCommandType `json:"type,omitempty"`
+	// the params + values from the user
+	Options []ApplicationCommandInteractionDataOption `json:"options,omitempty"`
+	// id of the user or message targeted by a user or message command
+	TargetID string `json:"target_id,omitempty"`
+	// converted users + messages referenced by the command
+	Resolved *ApplicationCommandInteractionDataResolved `json:"resolved,omitempty"`
+}
+
+type ApplicationCommandInteractionDataResolved struct {
+	// the ids and User objects
+	Users map[string]User `json:"users,omitempty"`
+	// the ids and partial Message objects
+	Messages map[string]Message `json:"messages,omitempty"`
+}
+
+// TargetMessage returns the message a message command was used on
+func (d ApplicationCommandInteractionData) TargetMessage() (Message, bool) {
+	if d.Resolved == nil || d.TargetID == "" {
+		return Message{}, false
+	}
+	msg, ok := d.Resolved.Messages[d.TargetID]
+	return msg, ok
+}
+
+type ApplicationCommandInteractionDataOption struct {
+	// the name of the parameter
+	Name string `json:"name"`
+	// value of ApplicationCommandOptionType
+	Type ApplicationCommandOptionType `json:"type"`
+	// the value of the pair, strings for ids, float64 for integers
+	Value interface{} `json:"value,omitempty"`
+	// present if this option is a group or subcommand
+	Options []ApplicationCommandInteractionDataOption `json:"options,omitempty"`
+}
+
+type ApplicationCommandType int
+
+const (
+	// slash commands, the default
+	CommandChatInput ApplicationCommandType = iota + 1
+	// commands in the context menu of a user
+	CommandUser
+	// commands in the context menu of a message
+	CommandMessage
+)
+
+type ApplicationCommandOptionType int
+
+const (
+	OptionSubCommand ApplicationCommandOptionType = iota + 1
+	OptionSubCommandGroup
+	OptionString
+	OptionInteger
+	OptionBoolean
+	OptionUser
+	OptionChannel
+	OptionRole
+	OptionMentionable
+)
+
+type ApplicationCommand struct {
+	// unique id of the command
+	ID string `json:"id,omitempty"`
+	// unique id of the parent application
+	ApplicationID string `json:"application_id,omitempty"`
+	// guild id of the command, if not global
+	GuildID string `json:"guild_id,omitempty"`
+	// the type of command, defaults to CommandChatInput
+	Type ApplicationCommandType `json:"type,omitempty"`
+	// 1-32 character name, lowercase matching ^[\w-]{1,32}$ for CommandChatInput
+	Name string `json:"name"`
+	// 1-100 character description for CommandChatInput, empty for user and message commands
+	Description string `json:"description"`
+	// the parameters for the command
+	Options []ApplicationCommandOption `json:"options,omitempty"`
+}
+
+type ApplicationCommandOption struct {
+	// value of ApplicationCommandOptionType
+	Type ApplicationCommandOptionType `json:"type"`
+	// 1-32 lowercase character name matching ^[\w-]{1,32}$
+	Name string `json:"name"`
+	// 1-100 character description
+	Description string `json:"description"`
+	// if the parameter is required or optional, default false
+	Required bool `json:"required,omitempty"`
+	// choices for string and int types for the user to pick from
+	Choices []ApplicationCommandOptionChoice `json:"choices,omitempty"`
+	// if the option is a subcommand or subcommand group type, this nested options will be the parameters
+	Options []ApplicationCommandOption `json:"options,omitempty"`
+}
+
+type ApplicationCommandOptionChoice struct {
+	// 1-100 character choice name
+	Name string `json:"name"`
+	// value of the choice, up to 100 characters if string
+	Value interface{} `json:"value"`
+}
+
+type InteractionCallbackType int
+
+const (
+	CallbackPong                             InteractionCallbackType = 1
+	CallbackChannelMessageWithSource         InteractionCallbackType = 4
+	CallbackDeferredChannelMessageWithSource InteractionCallbackType = 5
+)
+
+// MessageFlagEphemeral makes an interaction response only visible to the invoking user
+const MessageFlagEphemeral = 1 << 6
+
+type InteractionResponse struct {
+	// the type of response
+	Type InteractionCallbackType `json:"type"`
+	// an optional response message
+	Data *InteractionApplicationCommandCallbackData `json:"data,omitempty"`
+}
+
+type InteractionApplicationCommandCallbackData struct {
+	// is the response TTS
+	TTS bool `json:"tts,omitempty"`
+	// message content
+	Content string `json:"content,omitempty"`
+	// supports up to 10 embeds
+	Embeds []Embed `json:"embeds,omitempty"`
+	// allowed mentions object
+	AllowedMentions *AllowedMentions `json:"allowed_mentions,omitempty"`
+	// set to 64 to make your response ephemeral
+	Flags int `json:"flags,omitempty"`
+}
+
+type AllowedMentions struct {
+	// an array of allowed mention types to parse from the content, "roles", "users" or "everyone"
+	Parse []string `json:"parse"`
+	// array of user_ids to mention
+	Users []string `json:"users,omitempty"`
+}
+
+type Embed struct {
+	// title of embed
+	Title string `json:"title,omitempty"`
+	// description of embed
+	Description string `json:"description,omitempty"`
+	// url of embed
+	URL string `json:"url,omitempty"`
+	// timestamp of embed content, ISO8601 timestamp
+	Timestamp string `json:"timestamp,omitempty"`
+	// color code of the embed
+	Color int `json:"color,omitempty"`
+	// footer information
+	Footer *EmbedFooter `json:"footer,omitempty"`
+	// author information
+	Author *EmbedAuthor `json:"author,omitempty"`
+	// fields information
+	Fields []EmbedField `json:"fields,omitempty"`
+}
+
+// limits discord puts on embeds, a message going over any of them is rejected
+const (
+	EmbedDescriptionLimit = 4096
+	EmbedFieldValueLimit  = 1024
+	// of all the embeds of a message together, counted by Embed.Length
+	EmbedTotalLimit = 6000
+)
+
+// Length is the characters of the embed that count towards EmbedTotalLimit
+func (e Embed) Length() int {
+	n := utf8.RuneCountInString(e.Title) + utf8.RuneCountInString(e.Description)
+	if e.Footer != nil {
+		n += utf8.RuneCountInString(e.Footer.Text)
+	}
+	if e.Author != nil {
+		n += utf8.RuneCountInString(e.Author.Name)
+	}
+	for _, f := range e.Fields {
+		n += utf8.RuneCountInString(f.Name) + utf8.RuneCountInString(f.Value)
+	}
+	return n
+}
+
+type EmbedFooter struct {
+	// footer text
+	Text string `json:"text"`
+}
+
+type EmbedAuthor struct {
+	// name of author
+	Name string `json:"name,omitempty"`
+	// url of author
+	URL string `json:"url,omitempty"`
+	// url of author icon
+	IconURL string `json:"icon_url,omitempty"`
+}
+
+type EmbedField struct {
+	// name of the field
+	Name string `json:"name"`
+	// value of the field
+	Value string `json:"value"`
+	// whether or not this field should display inline
+	Inline bool `json:"inline,omitempty"`
+}
+
+var (
+	ErrInvalidSignature    = errors.New("invalid interaction signature")
+	ErrStaleInteraction    = errors.New("interaction timestamp is too far from now")
+	ErrInteractionTooLarge = errors.New("interaction body is too large")
+)
+
+// interactions are small, don't verify arbitrarily large bodies
+const MaxInteractionSize = 1 << 20
+
+// how far the signed timestamp may be from now, a captured request can't be
+// replayed once it's older than this
+const interactionMaxAge = 5 * time.Minute
+
+// VerifyInteraction reads the body of an interaction request and checks
+// discord's ed25519 signature of it against the application's public key
+func VerifyInteraction(r *http.Request, key ed25519.PublicKey) ([]byte, error) {
+	sig, err := hex.DecodeString(r.Header.Get("X-Signature-Ed25519"))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return nil, ErrInvalidSignature
+	}
+	timestamp := r.Header.Get("X-Signature-Timestamp")
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidSignature
+	}
+	if age := time.Since(time.Unix(unix, 0)); age > interactionMaxAge || age < -interactionMaxAge {
+		return nil, ErrStaleInteraction
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, MaxInteractionSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > MaxInteractionSize {
+		return nil, ErrInteractionTooLarge
+	}
+
+	if !ed25519.Verify(key, append([]byte(timestamp), body...), sig) {
+		return nil, ErrInvalidSignature
+	}
+	return body, nil
+}
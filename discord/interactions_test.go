@@ -0,0 +1,89 @@
+package discord
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the hex signature discord would send for body at timestamp
+func sign(priv ed25519.PrivateKey, timestamp string, body []byte) string {
+	return hex.EncodeToString(ed25519.Sign(priv, append([]byte(timestamp), body...)))
+}
+
+func TestVerifyInteraction(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherPriv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := []byte(`{"type": 1}`)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	later := strconv.FormatInt(time.Now().Unix()+1, 10)
+	stale := strconv.FormatInt(time.Now().Add(-10*time.Minute).Unix(), 10)
+	future := strconv.FormatInt(time.Now().Add(10*time.Minute).Unix(), 10)
+	large := []byte(`{"type": 1, "pad": "` + strings.Repeat("a", MaxInteractionSize) + `"}`)
+
+	tests := []struct {
+		name      string
+		sig       string
+		timestamp string
+		body      []byte
+		want      error
+	}{
+		{"valid", sign(priv, now, body), now, body, nil},
+		{"signed by another key", sign(otherPriv, now, body), now, body, ErrInvalidSignature},
+		{"changed body", sign(priv, now, body), now, []byte(`{"type": 2}`), ErrInvalidSignature},
+		{"changed timestamp", sign(priv, now, body), later, body, ErrInvalidSignature},
+		{"malformed signature", "not hex", now, body, ErrInvalidSignature},
+		{"short signature", "abcd", now, body, ErrInvalidSignature},
+		{"no signature", "", now, body, ErrInvalidSignature},
+		{"no timestamp", sign(priv, "", body), "", body, ErrInvalidSignature},
+		{"malformed timestamp", sign(priv, "soon", body), "soon", body, ErrInvalidSignature},
+		{"stale", sign(priv, stale, body), stale, body, ErrStaleInteraction},
+		{"from the future", sign(priv, future, body), future, body, ErrStaleInteraction},
+		{"too large", sign(priv, now, large), now, large, ErrInteractionTooLarge},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/interactions", bytes.NewReader(tt.body))
+		if tt.sig != "" {
+			r.Header.Set("X-Signature-Ed25519", tt.sig)
+		}
+		if tt.timestamp != "" {
+			r.Header.Set("X-Signature-Timestamp", tt.timestamp)
+		}
+
+		got, err := VerifyInteraction(r, pub)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+		if err == nil && !bytes.Equal(got, tt.body) {
+			t.Errorf("%s: got body %s, want %s", tt.name, got, tt.body)
+		}
+	}
+}
+
+func TestEmbedLength(t *testing.T) {
+	e := Embed{
+		Title:       "tïtle",
+		Description: "description",
+		URL:         "https://example.com/not/counted",
+		Footer:      &EmbedFooter{Text: "footer"},
+		Author:      &EmbedAuthor{Name: "author", URL: "https://example.com/"},
+		Fields:      []EmbedField{{Name: "name", Value: "value"}, {Name: "n", Value: "v"}},
+	}
+	if n := e.Length(); n != 5+11+6+6+4+5+1+1 {
+		t.Errorf("got %d, want %d", n, 5+11+6+6+4+5+1+1)
+	}
+}
@@ -0,0 +1,27 @@
+package discord
+
+// metrics of the requests made by every Client
+//
+//	steno_discord_requests_total{method,route,status}    responses, status is "error" when there wasn't one
+//	steno_discord_request_duration_seconds{method,route} time taken by each attempt
+//	steno_discord_rate_limited_total{scope}              429 responses, scope is global or route
+//	steno_discord_rate_limit_waits_total{result}         requests held back by a known limit, waited or gave_up
+
+import (
+	"steno/metrics"
+)
+
+var requestsTotal = metrics.Default.Counter("steno_discord_requests_total",
+	"Requests made to the discord api by route and status.", "method", "route", "status")
+var requestDuration = metrics.Default.Histogram("steno_discord_request_duration_seconds",
+	"Time taken by requests to the discord api.", nil, "method", "route")
+var rateLimited = metrics.Default.Counter("steno_discord_rate_limited_total",
+	"Requests discord answered with a 429.", "scope")
+var rateLimitWaits = metrics.Default.Counter("steno_discord_rate_limit_waits_total",
+	"Requests held back by a rate limit before being sent.", "result")
+
+// the route label of endpoint, every id is replaced so routes don't make a
+// series per guild or user
+func metricRoute(endpoint string) string {
+	return snowflakeSegment.ReplaceAllString(endpoint, "/:id")
+}
@@ -0,0 +1,124 @@
+package discord
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Permissions is a discord permission bit set, the api sends them as
+// strings of the decimal value
+type Permissions uint64
+
+const (
+	PermissionCreateInstantInvite Permissions = 1 << iota
+	PermissionKickMembers
+	PermissionBanMembers
+	PermissionAdministrator
+	PermissionManageChannels
+	PermissionManageGuild
+	PermissionAddReactions
+	PermissionViewAuditLog
+	PermissionPrioritySpeaker
+	PermissionStream
+	PermissionViewChannel
+	PermissionSendMessages
+	PermissionSendTTSMessages
+	PermissionManageMessages
+	PermissionEmbedLinks
+	PermissionAttachFiles
+	PermissionReadMessageHistory
+	PermissionMentionEveryone
+	PermissionUseExternalEmojis
+	PermissionViewGuildInsights
+	PermissionConnect
+	PermissionSpeak
+	PermissionMuteMembers
+	PermissionDeafenMembers
+	PermissionMoveMembers
+	PermissionUseVAD
+	PermissionChangeNickname
+	PermissionManageNicknames
+	PermissionManageRoles
+	PermissionManageWebhooks
+	PermissionManageEmojis
+	PermissionUseSlashCommands
+	PermissionRequestToSpeak
+
+	PermissionAll Permissions = 1<<64 - 1
+)
+
+var permissionNames = []string{
+	"CREATE_INSTANT_INVITE", "KICK_MEMBERS", "BAN_MEMBERS", "ADMINISTRATOR",
+	"MANAGE_CHANNELS", "MANAGE_GUILD", "ADD_REACTIONS", "VIEW_AUDIT_LOG",
+	"PRIORITY_SPEAKER", "STREAM", "VIEW_CHANNEL", "SEND_MESSAGES",
+	"SEND_TTS_MESSAGES", "MANAGE_MESSAGES", "EMBED_LINKS", "ATTACH_FILES",
+	"READ_MESSAGE_HISTORY", "MENTION_EVERYONE", "USE_EXTERNAL_EMOJIS", "VIEW_GUILD_INSIGHTS",
+	"CONNECT", "SPEAK", "MUTE_MEMBERS", "DEAFEN_MEMBERS",
+	"MOVE_MEMBERS", "USE_VAD", "CHANGE_NICKNAME", "MANAGE_NICKNAMES",
+	"MANAGE_ROLES", "MANAGE_WEBHOOKS", "MANAGE_EMOJIS", "USE_SLASH_COMMANDS",
+	"REQUEST_TO_SPEAK",
+}
+
+// ParsePermissions parses the string form of a permission bit set, the
+// empty string is no permissions
+func ParsePermissions(s string) (Permissions, error) {
+	if s == "" {
+		return 0, nil
+	}
+	p, err := strconv.ParseUint(s, 10, 64)
+	return Permissions(p), err
+}
+
+// Has reports whether every bit of perm is set, ADMINISTRATOR grants everything
+func (p Permissions) Has(perm Permissions) bool {
+	if p&PermissionAdministrator != 0 {
+		return true
+	}
+	return p&perm == perm
+}
+
+func (p Permissions) String() string {
+	names := make([]string, 0)
+	for i, name := range permissionNames {
+		if p&(1<<uint(i)) != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// ComputePermissions returns the guild wide permissions of member from the
+// @everyone role and the member's roles, channel overwrites are not applied
+func ComputePermissions(guild Guild, member GuildMember) Permissions {
+	if guild.OwnerID != "" && member.User.ID == guild.OwnerID {
+		return PermissionAll
+	}
+
+	memberRoles := make(map[string]bool, len(member.Roles))
+	for _, id := range member.Roles {
+		memberRoles[id] = true
+	}
+
+	var perms Permissions
+	for _, role := range guild.Roles {
+		// the @everyone role shares the guild's id
+		if role.ID == guild.ID || memberRoles[role.ID] {
+			p, _ := ParsePermissions(role.Permissions)
+			perms |= p
+		}
+	}
+
+	if perms&PermissionAdministrator != 0 {
+		return PermissionAll
+	}
+	return perms
+}
+
+// GuildPermissions returns the permissions of the current user in a guild
+// from the GET /users/@me/guilds endpoint, owners have every permission
+func GuildPermissions(guild Guild) (Permissions, error) {
+	if guild.Owner {
+		return PermissionAll, nil
+	}
+	return ParsePermissions(guild.Permissions)
+}
@@ -0,0 +1,97 @@
+package discord
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Epoch is the first second of 2015, snowflake timestamps count milliseconds from it
+const Epoch = 1420070400000
+
+// Snowflake is a discord id, the api sends them as strings since they
+// don't fit in a javascript number
+//
+//	bits 63-22 milliseconds since Epoch
+//	bits 21-17 internal worker id
+//	bits 16-12 internal process id
+//	bits 11-0  increment for every id generated on that process
+type Snowflake uint64
+
+// ParseSnowflake parses the string form of an id, ids are the decimal
+// digits of a non zero uint64 and at least 15 digits long, which every id
+// since discord launched in 2015 is
+func ParseSnowflake(s string) (Snowflake, error) {
+	if len(s) < 15 || len(s) > 20 {
+		return 0, fmt.Errorf("invalid snowflake %q, expected 15 to 20 digits", s)
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return 0, fmt.Errorf("invalid snowflake %q, expected only digits", s)
+		}
+	}
+
+	id, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid snowflake %q, out of range", s)
+	}
+	return Snowflake(id), nil
+}
+
+// IsSnowflake reports whether s is a well formed discord id
+func IsSnowflake(s string) bool {
+	_, err := ParseSnowflake(s)
+	return err == nil
+}
+
+func (s Snowflake) String() string {
+	return strconv.FormatUint(uint64(s), 10)
+}
+
+// Time is when the id was generated
+func (s Snowflake) Time() time.Time {
+	ms := int64(s>>22) + Epoch
+	return time.Unix(ms/1000, (ms%1000)*int64(time.Millisecond)).UTC()
+}
+
+func (s Snowflake) WorkerID() int {
+	return int((s >> 17) & 0x1f)
+}
+
+func (s Snowflake) ProcessID() int {
+	return int((s >> 12) & 0x1f)
+}
+
+// Sequence is the increment of the id on the process that generated it
+func (s Snowflake) Sequence() int {
+	return int(s & 0xfff)
+}
+
+func (s Snowflake) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// UnmarshalJSON accepts ids as strings or numbers, null leaves s unchanged
+func (s *Snowflake) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		var n json.Number
+		if json.Unmarshal(data, &n) != nil {
+			return errors.New("invalid snowflake, expected a string or number")
+		}
+		str = n.String()
+	}
+
+	id, err := ParseSnowflake(str)
+	if err != nil {
+		return err
+	}
+	*s = id
+	return nil
+}
@@ -3,9 +3,11 @@ module steno
 go 1.16
 
 require (
+	github.com/alicebob/miniredis/v2 v2.14.3
 	github.com/go-redis/redis/v8 v8.7.1
 	github.com/google/uuid v1.2.0
 	github.com/julienschmidt/httprouter v1.3.0
 	golang.org/x/lint v0.0.0-20201208152925-83fdc39ff7b5 // indirect
+	golang.org/x/text v0.3.3
 	golang.org/x/tools v0.1.0 // indirect
 )
@@ -0,0 +1,211 @@
+// Package guildcache caches who a discord token belongs to and the guilds
+// it has access to so every request doesn't have to ask discord for them
+package guildcache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"steno/discord"
+)
+
+// Access is the user a token belongs to and the guilds it has access to
+type Access struct {
+	User   discord.User    `json:"user"`
+	Guilds []discord.Guild `json:"guilds"`
+}
+
+// Guild returns the guild from Guilds with guildID, ok is false if the
+// token doesn't have access to it
+func (a Access) Guild(guildID discord.Snowflake) (guild discord.Guild, ok bool) {
+	for _, guild := range a.Guilds {
+		if guild.ID == guildID.String() {
+			return guild, true
+		}
+	}
+	return discord.Guild{}, false
+}
+
+// Cache maps a token hash to the token's Access, entries expire after the
+// cache's ttl
+type Cache interface {
+	// Get returns the cached access, ok is false on a miss
+	Get(ctx context.Context, key string) (access Access, ok bool, err error)
+	Set(ctx context.Context, key string, access Access) error
+}
+
+// HashToken returns the cache key for an Authorization header, tokens are
+// never stored as is
+func HashToken(auth string) string {
+	sum := sha256.Sum256([]byte(auth))
+	return hex.EncodeToString(sum[:])
+}
+
+type memoryEntry struct {
+	access  Access
+	expires time.Time
+}
+
+// MemoryCache is a Cache local to this process
+type MemoryCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]memoryEntry
+	sweep   time.Time
+}
+
+func MemoryCacheNew(ttl time.Duration) *MemoryCache {
+	return &MemoryCache{
+		ttl:     ttl,
+		entries: make(map[string]memoryEntry),
+		sweep:   time.Now().Add(ttl),
+	}
+}
+
+func (c *MemoryCache) Get(ctx context.Context, key string) (Access, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return Access{}, false, nil
+	}
+	if time.Now().After(e.expires) {
+		delete(c.entries, key)
+		return Access{}, false, nil
+	}
+	return e.access, true, nil
+}
+
+func (c *MemoryCache) Set(ctx context.Context, key string, access Access) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	// drop tokens that stopped being used once a ttl so the map doesn't grow forever
+	if now.After(c.sweep) {
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		c.sweep = now.Add(c.ttl)
+	}
+
+	c.entries[key] = memoryEntry{access: access, expires: now.Add(c.ttl)}
+	return nil
+}
+
+// in flight or finished call of Group.Do
+type call struct {
+	done   chan struct{}
+	access Access
+	err    error
+}
+
+// how long a shared lookup may run, it no longer ends with the caller that started it
+const fetchTimeout = 15 * time.Second
+
+// detached carries the values of its parent but none of its deadline or
+// cancelation, so one caller going away doesn't fail everyone waiting
+type detached struct{ parent context.Context }
+
+func (detached) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detached) Done() <-chan struct{}               { return nil }
+func (detached) Err() error                          { return nil }
+func (d detached) Value(key interface{}) interface{} { return d.parent.Value(key) }
+
+// Group dedupes concurrent lookups of the same key, callers that arrive
+// while a lookup is running wait for it and share its result
+// fn runs detached from the caller that started the lookup with a timeout of
+// its own, every caller stops waiting when their own context is done
+type Group struct {
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+func (g *Group) Do(ctx context.Context, key string, fn func(ctx context.Context) (Access, error)) (Access, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*call)
+	}
+	c, ok := g.calls[key]
+	if !ok {
+		c = &call{done: make(chan struct{})}
+		g.calls[key] = c
+		go g.run(ctx, key, c, fn)
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.access, c.err
+	case <-ctx.Done():
+		return Access{}, ctx.Err()
+	}
+}
+
+// run fn for everyone waiting on c, then release them and the key
+func (g *Group) run(ctx context.Context, key string, c *call, fn func(ctx context.Context) (Access, error)) {
+	defer func() {
+		// no caller's recovery can see a panic in this goroutine, hand it to them as an error
+		if r := recover(); r != nil {
+			c.access, c.err = Access{}, fmt.Errorf("guildcache: access lookup panicked/%v", r)
+		}
+
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(c.done)
+	}()
+
+	fetchCtx, cancel := context.WithTimeout(detached{ctx}, fetchTimeout)
+	defer cancel()
+	c.access, c.err = fn(fetchCtx)
+}
+
+// Loader reads through a Cache, fetching and storing the access on a miss,
+// a nil Cache only dedupes concurrent fetches
+type Loader struct {
+	Cache Cache
+	group Group
+}
+
+func LoaderNew(cache Cache) *Loader {
+	return &Loader{Cache: cache}
+}
+
+// Access returns who auth belongs to and the guilds it has access to, fetch
+// is only called on a cache miss and only once for concurrent requests with
+// the same token
+func (l *Loader) Access(ctx context.Context, auth string, fetch func(ctx context.Context) (Access, error)) (Access, error) {
+	key := HashToken(auth)
+	if l.Cache != nil {
+		access, ok, err := l.Cache.Get(ctx, key)
+		switch {
+		case err != nil:
+			cacheLookups.Inc("access", "error")
+		case ok:
+			cacheLookups.Inc("access", "hit")
+			return access, nil
+		default:
+			cacheLookups.Inc("access", "miss")
+		}
+	}
+
+	return l.group.Do(ctx, key, func(ctx context.Context) (Access, error) {
+		access, err := fetch(ctx)
+		if err != nil {
+			return Access{}, err
+		}
+		if l.Cache != nil {
+			// a failed write only costs another fetch next time
+			_ = l.Cache.Set(ctx, key, access)
+		}
+		return access, nil
+	})
+}
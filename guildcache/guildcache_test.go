@@ -0,0 +1,113 @@
+package guildcache
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"steno/discord"
+)
+
+func TestGroupOutlivesLeader(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	var calls int32
+	fetch := func(ctx context.Context) (Access, error) {
+		atomic.AddInt32(&calls, 1)
+		select {
+		case <-release:
+			return Access{Guilds: []discord.Guild{{ID: "100000000000000001"}}}, nil
+		case <-ctx.Done():
+			return Access{}, ctx.Err()
+		}
+	}
+
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	leader := make(chan error)
+	go func() {
+		_, err := g.Do(leaderCtx, "token", fetch)
+		leader <- err
+	}()
+
+	follower := make(chan error)
+	go func() {
+		// wait for the leader's lookup to be in flight before joining it
+		for atomic.LoadInt32(&calls) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		access, err := g.Do(context.Background(), "token", fetch)
+		if err == nil && len(access.Guilds) != 1 {
+			err = errors.New("got no guilds")
+		}
+		follower <- err
+	}()
+
+	for atomic.LoadInt32(&calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancelLeader()
+	if err := <-leader; !errors.Is(err, context.Canceled) {
+		t.Errorf("leader: got %v, want context.Canceled", err)
+	}
+
+	// give the follower time to join before the lookup finishes
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	if err := <-follower; err != nil {
+		t.Errorf("follower: got %v, want the leader's lookup to finish", err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("got %d lookups, want 1", n)
+	}
+}
+
+func TestGroupPanic(t *testing.T) {
+	var g Group
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	_, err := g.Do(ctx, "token", func(ctx context.Context) (Access, error) {
+		panic("boom")
+	})
+	if err == nil || errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want the panic as an error", err)
+	}
+
+	// the key was released so the next lookup runs
+	access, err := g.Do(ctx, "token", func(ctx context.Context) (Access, error) {
+		return Access{Guilds: []discord.Guild{{ID: "100000000000000001"}}}, nil
+	})
+	if err != nil || len(access.Guilds) != 1 {
+		t.Errorf("after a panic: got %v %v, want a fresh lookup", access, err)
+	}
+}
+
+func TestLoaderCachesAccess(t *testing.T) {
+	l := LoaderNew(MemoryCacheNew(time.Minute))
+	var calls int
+	fetch := func(ctx context.Context) (Access, error) {
+		calls++
+		return Access{
+			User:   discord.User{ID: "200000000000000001"},
+			Guilds: []discord.Guild{{ID: "100000000000000001"}},
+		}, nil
+	}
+
+	for i := 0; i < 2; i++ {
+		access, err := l.Access(context.Background(), "Bearer token", fetch)
+		if err != nil || access.User.ID != "200000000000000001" {
+			t.Fatalf("lookup %d: got %v %v, want the token's user", i, access, err)
+		}
+		if _, ok := access.Guild(100000000000000001); !ok {
+			t.Errorf("lookup %d: got no access to the token's guild", i)
+		}
+		if _, ok := access.Guild(100000000000000002); ok {
+			t.Errorf("lookup %d: got access to another guild", i)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("got %d fetches, want 1", calls)
+	}
+}
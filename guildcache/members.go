@@ -0,0 +1,108 @@
+package guildcache
+
+import (
+	"sync"
+	"time"
+
+	"steno/discord"
+)
+
+type memberEntry struct {
+	member  *discord.GuildMember
+	expires time.Time
+}
+
+type guildEntry struct {
+	guild   discord.Guild
+	expires time.Time
+}
+
+// MemberCache remembers the members of guilds and the guilds' roles to
+// compute their permissions from, users that aren't members are cached too
+// so made up ids don't hit discord every time
+type MemberCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[memberKey]memberEntry
+	guilds  map[discord.Snowflake]guildEntry
+	sweep   time.Time
+}
+
+func MemberCacheNew(ttl time.Duration) *MemberCache {
+	return &MemberCache{
+		ttl:     ttl,
+		entries: make(map[memberKey]memberEntry),
+		guilds:  make(map[discord.Snowflake]guildEntry),
+		sweep:   time.Now().Add(ttl),
+	}
+}
+
+type memberKey struct {
+	guildID, userID discord.Snowflake
+}
+
+// Member returns userID's member of guildID, nil if they aren't a member,
+// ok is false on a miss
+func (c *MemberCache) Member(guildID, userID discord.Snowflake) (member *discord.GuildMember, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[memberKey{guildID, userID}]
+	if !ok || time.Now().After(e.expires) {
+		cacheLookups.Inc("members", "miss")
+		return nil, false
+	}
+	cacheLookups.Inc("members", "hit")
+	return e.member, true
+}
+
+// SetMember caches userID's member of guildID, nil if they aren't a member
+func (c *MemberCache) SetMember(guildID, userID discord.Snowflake, member *discord.GuildMember) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expire()
+	c.entries[memberKey{guildID, userID}] = memberEntry{member: member, expires: time.Now().Add(c.ttl)}
+}
+
+// Guild returns the cached guild, ok is false on a miss
+func (c *MemberCache) Guild(guildID discord.Snowflake) (guild discord.Guild, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.guilds[guildID]
+	if !ok || time.Now().After(e.expires) {
+		cacheLookups.Inc("guild_roles", "miss")
+		return discord.Guild{}, false
+	}
+	cacheLookups.Inc("guild_roles", "hit")
+	return e.guild, true
+}
+
+func (c *MemberCache) SetGuild(guildID discord.Snowflake, guild discord.Guild) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expire()
+	c.guilds[guildID] = guildEntry{guild: guild, expires: time.Now().Add(c.ttl)}
+}
+
+// drop entries that expired once a ttl so the maps don't grow forever,
+// c.mu has to be held
+func (c *MemberCache) expire() {
+	now := time.Now()
+	if !now.After(c.sweep) {
+		return
+	}
+	for k, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+	for k, e := range c.guilds {
+		if now.After(e.expires) {
+			delete(c.guilds, k)
+		}
+	}
+	c.sweep = now.Add(c.ttl)
+}
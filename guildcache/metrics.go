@@ -0,0 +1,14 @@
+package guildcache
+
+// steno_cache_lookups_total{cache,result} counts lookups by whether they
+// were answered from the cache, the hit ratio of a cache is
+//
+//	sum(rate(steno_cache_lookups_total{result="hit"}[5m])) by (cache)
+//	  / sum(rate(steno_cache_lookups_total[5m])) by (cache)
+
+import (
+	"steno/metrics"
+)
+
+var cacheLookups = metrics.Default.Counter("steno_cache_lookups_total",
+	"Cache lookups by cache and result, result is hit, miss or error.", "cache", "result")
@@ -0,0 +1,63 @@
+package guildcache
+
+// redis backed cache shared between api replicas
+//
+// key schema
+//	guild_access:{token hash}  json Access of the token, expires after the ttl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+type RedisCache struct {
+	db  *redis.Client
+	ttl time.Duration
+}
+
+func Connect(addr string, pass string, nDB int, ttl time.Duration) RedisCache {
+	var db = redis.NewClient(&redis.Options{
+		Addr:     addr,
+		Password: pass,
+		DB:       nDB,
+	})
+
+	return RedisCache{db: db, ttl: ttl}
+}
+
+// Ping checks that redis is reachable
+func (c RedisCache) Ping(ctx context.Context) error {
+	return c.db.Ping(ctx).Err()
+}
+
+func guildAccessURI(key string) string {
+	return fmt.Sprintf("guild_access:%s", key)
+}
+
+func (c RedisCache) Get(ctx context.Context, key string) (Access, bool, error) {
+	data, err := c.db.Get(ctx, guildAccessURI(key)).Bytes()
+	if err == redis.Nil {
+		return Access{}, false, nil
+	} else if err != nil {
+		return Access{}, false, err
+	}
+
+	var access Access
+	err = json.Unmarshal(data, &access)
+	if err != nil {
+		return Access{}, false, err
+	}
+	return access, true, nil
+}
+
+func (c RedisCache) Set(ctx context.Context, key string, access Access) error {
+	data, err := json.Marshal(access)
+	if err != nil {
+		return err
+	}
+	return c.db.Set(ctx, guildAccessURI(key), data, c.ttl).Err()
+}
@@ -0,0 +1,111 @@
+package main
+
+// /healthz answers as long as the process is serving, /readyz checks the
+// services steno depends on and is 503 while a required one is down
+//
+//	{
+//	  "status": "unavailable",
+//	  "checks": {
+//	    "redis": {"status": "down", "required": true, "latency_ms": 2000.4, "error": "context deadline exceeded"},
+//	    "discord": {"status": "ok", "required": false, "latency_ms": 85.2}
+//	  }
+//	}
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// a dependency checked by /readyz, steno isn't ready while a required one is down
+type dependencyCheck struct {
+	name     string
+	required bool
+	check    func(ctx context.Context) error
+}
+
+// the checks /readyz runs, set up in main alongside the dependencies
+var readinessChecks []dependencyCheck
+
+// how long a dependency has to answer before it counts as down
+const checkTimeout = 2 * time.Second
+
+type checkResult struct {
+	Status    string  `json:"status"` // ok or down
+	Required  bool    `json:"required"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type readiness struct {
+	Status string                 `json:"status"` // ok or unavailable
+	Checks map[string]checkResult `json:"checks"`
+}
+
+func writeJSONStatus(w http.ResponseWriter, status int, v interface{}) (int, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("json marshal failed/%s", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write(body)
+	return status, nil
+}
+
+/**
+ * Handler for liveness probes, nothing is checked
+ */
+func getHealth(_ context.Context, w http.ResponseWriter, _ *http.Request, _ httprouter.Params) (int, error) {
+	return writeJSONStatus(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+/**
+ * Handler for readiness probes, checks every dependency at once
+ *
+ * 200 when every required dependency is up, 503 otherwise. dependencies
+ * that aren't required are reported without affecting the status
+ */
+func getReadiness(ctx context.Context, w http.ResponseWriter, _ *http.Request, _ httprouter.Params) (int, error) {
+	results := make([]checkResult, len(readinessChecks))
+	var wg sync.WaitGroup
+	for i, dep := range readinessChecks {
+		wg.Add(1)
+		go func(i int, dep dependencyCheck) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, checkTimeout)
+			defer cancel()
+
+			start := time.Now()
+			err := dep.check(checkCtx)
+			results[i] = checkResult{
+				Status:    "ok",
+				Required:  dep.required,
+				LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				results[i].Status = "down"
+				results[i].Error = err.Error()
+			}
+		}(i, dep)
+	}
+	wg.Wait()
+
+	out := readiness{Status: "ok", Checks: make(map[string]checkResult, len(results))}
+	status := http.StatusOK
+	for i, dep := range readinessChecks {
+		out.Checks[dep.name] = results[i]
+		if dep.required && results[i].Status != "ok" {
+			out.Status = "unavailable"
+			status = http.StatusServiceUnavailable
+		}
+	}
+	return writeJSONStatus(w, status, out)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"steno/quotestore"
+)
+
+// GET /readyz with checks
+func readyz(t *testing.T, checks []dependencyCheck) (int, readiness) {
+	t.Helper()
+	readinessChecks = checks
+	t.Cleanup(func() { readinessChecks = nil })
+
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	var out readiness
+	if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+		t.Fatalf("invalid readiness %s", w.Body)
+	}
+	return w.Code, out
+}
+
+func TestReadiness(t *testing.T) {
+	srv := setup(t)
+	m, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	redisStore := quotestore.Connect(m.Addr(), "", 0)
+
+	// wired up like main with STENO_READY_DISCORD=optional
+	checks := []dependencyCheck{
+		{name: "redis", required: true, check: redisStore.Ping},
+		{name: "discord", required: false, check: func(ctx context.Context) error {
+			_, err := discordClient.Gateway(ctx)
+			return err
+		}},
+	}
+
+	status, out := readyz(t, checks)
+	if status != http.StatusOK || out.Status != "ok" ||
+		out.Checks["redis"].Status != "ok" || out.Checks["discord"].Status != "ok" {
+		t.Errorf("all up: got %d %+v, want 200 with every check ok", status, out)
+	}
+
+	// discord being down is reported but steno can still serve quotes
+	srv.Close()
+	status, out = readyz(t, checks)
+	if status != http.StatusOK || out.Status != "ok" ||
+		out.Checks["discord"].Status != "down" || out.Checks["discord"].Error == "" || out.Checks["discord"].Required {
+		t.Errorf("discord down: got %d %+v, want 200 with discord down", status, out)
+	}
+
+	m.Close()
+	status, out = readyz(t, checks)
+	if status != http.StatusServiceUnavailable || out.Status != "unavailable" ||
+		out.Checks["redis"].Status != "down" || !out.Checks["redis"].Required {
+		t.Errorf("redis down: got %d %+v, want 503 with redis down", status, out)
+	}
+}
+
+func TestReadinessTimeout(t *testing.T) {
+	setup(t)
+	hang := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	checks := []dependencyCheck{
+		{name: "redis", required: true, check: hang},
+		{name: "discord", required: false, check: hang},
+		{name: "cache", required: false, check: func(context.Context) error { return nil }},
+	}
+
+	start := time.Now()
+	status, out := readyz(t, checks)
+	elapsed := time.Since(start)
+
+	// the checks run at once, hanging ones give up after checkTimeout each
+	if elapsed < checkTimeout || elapsed >= 2*checkTimeout {
+		t.Errorf("took %s, want the hanging checks to time out together after %s", elapsed, checkTimeout)
+	}
+	if status != http.StatusServiceUnavailable || out.Status != "unavailable" {
+		t.Errorf("got %d %q, want 503 unavailable", status, out.Status)
+	}
+	for _, name := range []string{"redis", "discord"} {
+		if c := out.Checks[name]; c.Status != "down" || c.Error != context.DeadlineExceeded.Error() || c.LatencyMS < 1000*checkTimeout.Seconds() {
+			t.Errorf("%s: got %+v, want down after %s", name, c, checkTimeout)
+		}
+	}
+	if c := out.Checks["cache"]; c.Status != "ok" {
+		t.Errorf("cache: got %+v, want ok", c)
+	}
+}
@@ -1,18 +1,29 @@
 package httptools
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"reflect"
 	"runtime"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 )
 
-type RouteHandle func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) (int, error)
+// RouteHandle handles part of a request, ctx is done when the client goes
+// away or the route's timeout runs out and should be passed to anything
+// that could block
+type RouteHandle func(ctx context.Context, w http.ResponseWriter, r *http.Request, ps httprouter.Params) (int, error)
 type Route struct {
 	handlers []RouteHandle
+
+	pattern string        // the router pattern, for logs
+	log     bool          // write an access log line after every request
+	timeout time.Duration // deadline for all the handlers, 0 for none
 }
 
 func RouteNew() Route {
@@ -23,22 +34,73 @@ func RouteNew() Route {
 }
 
 func (rt Route) Clone() Route {
-	newRoute := RouteNew()
+	newRoute := rt
 	newRoute.handlers = make([]RouteHandle, len(rt.handlers))
 	copy(newRoute.handlers, rt.handlers)
 
 	return newRoute
 }
 
+// values set by handlers for the handlers after them in the same route
+type routeValues map[interface{}]interface{}
+type routeValuesKey struct{}
+
+// SetValue stores val under key for the rest of the route handling r
+func SetValue(r *http.Request, key, val interface{}) {
+	if values, ok := r.Context().Value(routeValuesKey{}).(routeValues); ok {
+		values[key] = val
+	}
+}
+
+// Value returns the value stored under key by an earlier handler, or nil
+func Value(r *http.Request, key interface{}) interface{} {
+	if values, ok := r.Context().Value(routeValuesKey{}).(routeValues); ok {
+		return values[key]
+	}
+	return nil
+}
+
+// Handle runs the route's handlers in order until one returns an error,
+// which is written as a Problem. panics are recovered into 500s and
+// handlers that fail after running out of time are answered with a 504
 func (rt Route) Handle() httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+
+		ctx := r.Context()
+		if rt.timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, rt.timeout)
+			defer cancel()
+		}
+		ctx = context.WithValue(ctx, routeValuesKey{}, routeValues{})
+		r = r.WithContext(ctx)
+		SetValue(r, requestIDKey{}, newRequestID(r))
+		sw.Header().Set("X-Request-ID", RequestID(r))
+
+		defer func() {
+			rec := recover()
+			abort := rec != nil && rt.recoverPanic(sw, r, rec)
+			rt.observe(sw, r, start)
+			if rt.log {
+				rt.logAccess(sw, r, start, rec != nil)
+			}
+			if abort {
+				panic(http.ErrAbortHandler)
+			}
+		}()
+
 		for _, h := range rt.handlers {
-			status, err := h(w, r, ps)
+			status, err := h(ctx, sw, r, ps)
 			if err != nil {
-				http.Error(w, fmt.Sprintf("steno: %s", err.Error()), status)
-				log.Printf("ERROR/handler/%s %s\n",
+				p := problemFrom(ctx, status, err)
+				p.Instance = r.URL.Path
+				p.RequestID = RequestID(r)
+				WriteProblem(sw, p)
+				log.Printf("ERROR/handler/%s %s %s\n",
 					runtime.FuncForPC(reflect.ValueOf(h).Pointer()).Name(),
-					err.Error())
+					p.RequestID, err.Error())
 				break
 			}
 		}
@@ -51,7 +113,7 @@ func (rt Route) Finish(handler RouteHandle) httprouter.Handle {
 
 func (rt Route) Apply(handler httprouter.Handle) Route {
 	rt.handlers = append(rt.handlers,
-		func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) (int, error) {
+		func(ctx context.Context, w http.ResponseWriter, r *http.Request, ps httprouter.Params) (int, error) {
 			handler(w, r, ps)
 			return http.StatusOK, nil
 		})
@@ -63,10 +125,46 @@ func (rt Route) Gate(handler RouteHandle) Route {
 	return rt
 }
 
+// Log writes a json access log line to AccessLog after every request
 func (rt Route) Log() Route {
-	return rt.Apply(httplog)
+	rt.log = true
+	return rt
+}
+
+// Timeout gives the route's handlers d to answer, their ctx is canceled
+// after that so slow calls fail instead of holding the request open
+func (rt Route) Timeout(d time.Duration) Route {
+	rt.timeout = d
+	return rt
+}
+
+// Pattern names the route in logs, httprouter doesn't tell handlers which
+// pattern matched so it has to be given
+func (rt Route) Pattern(pattern string) Route {
+	rt.pattern = pattern
+	return rt
 }
 
-func httplog(_ http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	log.Printf("%s %s --- %s %s", r.UserAgent(), r.RemoteAddr, r.Method, r.URL)
+// Register finishes a copy of the route with handler and adds it to router
+// under method and pattern
+func (rt Route) Register(router *httprouter.Router, method, pattern string, handler RouteHandle) {
+	router.Handle(method, pattern, rt.Clone().Pattern(pattern).Finish(handler))
+}
+
+// FieldErrors maps the json names of invalid request fields to what is
+// wrong with them, returned as the fields of a Validation problem
+type FieldErrors map[string]string
+
+func (fe FieldErrors) Error() string {
+	fields := make([]string, 0, len(fe))
+	for field := range fe {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	msgs := make([]string, len(fields))
+	for i, field := range fields {
+		msgs[i] = fmt.Sprintf("%s %s", field, fe[field])
+	}
+	return "invalid fields, " + strings.Join(msgs, ", ")
 }
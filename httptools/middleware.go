@@ -0,0 +1,146 @@
+package httptools
+
+// what Route.Handle does around the handlers, recovering panics, logging
+// every request once it has been answered and counting it in the metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"time"
+
+	"steno/metrics"
+)
+
+// AccessLog is where access log lines are written, one json object per line
+var AccessLog io.Writer = os.Stdout
+
+var requestsTotal = metrics.Default.Counter("steno_http_requests_total",
+	"Requests answered by route and status.", "method", "route", "status")
+var requestDuration = metrics.Default.Histogram("steno_http_request_duration_seconds",
+	"Time taken to answer requests by route and status.", nil, "method", "route", "status")
+
+type accessLine struct {
+	Time       string  `json:"time"`
+	RequestID  string  `json:"request_id"`
+	Method     string  `json:"method"`
+	Route      string  `json:"route"`
+	Path       string  `json:"path"`
+	Status     int     `json:"status"`
+	Bytes      int     `json:"bytes"`
+	DurationMS float64 `json:"duration_ms"`
+	User       string  `json:"user,omitempty"`
+	RemoteAddr string  `json:"remote_addr"`
+	UserAgent  string  `json:"user_agent,omitempty"`
+	Panic      bool    `json:"panic,omitempty"`
+}
+
+// statusWriter remembers what was written for the access log
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if sw.status == 0 {
+		sw.status = status
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	n, err := sw.ResponseWriter.Write(b)
+	sw.bytes += n
+	return n, err
+}
+
+type userKey struct{}
+
+// SetUser records who made the request for the access log
+func SetUser(r *http.Request, userID string) {
+	SetValue(r, userKey{}, userID)
+}
+
+// User returns the id set with SetUser, empty for anonymous requests
+func User(r *http.Request) string {
+	id, _ := Value(r, userKey{}).(string)
+	return id
+}
+
+// answer a panicking handler with a 500, returns true if the connection
+// has to be aborted instead since the handler already started writing
+func (rt Route) recoverPanic(sw *statusWriter, r *http.Request, rec interface{}) bool {
+	if rec == http.ErrAbortHandler {
+		return true
+	}
+	log.Printf("ERROR/panic %s %s %s %v\n%s", RequestID(r), r.Method, rt.pattern, rec, debug.Stack())
+
+	if sw.status != 0 {
+		return true
+	}
+	p := ProblemNew(http.StatusInternalServerError, "internal_error", "internal error")
+	p.Instance = r.URL.Path
+	p.RequestID = RequestID(r)
+	WriteProblem(sw, p)
+	return false
+}
+
+func (rt Route) logAccess(sw *statusWriter, r *http.Request, start time.Time, panicked bool) {
+	status := sw.status
+	if status == 0 {
+		// nothing was written, net/http sends an empty 200
+		status = http.StatusOK
+	}
+
+	route := rt.pattern
+	if route == "" {
+		route = r.URL.Path
+	}
+
+	line, err := json.Marshal(accessLine{
+		Time:       start.UTC().Format(time.RFC3339Nano),
+		RequestID:  RequestID(r),
+		Method:     r.Method,
+		Route:      route,
+		Path:       r.URL.Path,
+		Status:     status,
+		Bytes:      sw.bytes,
+		DurationMS: float64(time.Since(start).Microseconds()) / 1000,
+		User:       User(r),
+		RemoteAddr: r.RemoteAddr,
+		UserAgent:  r.UserAgent(),
+		Panic:      panicked,
+	})
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(AccessLog, "%s\n", line)
+}
+
+// count the request in the metrics, every route is counted even without Log
+func (rt Route) observe(sw *statusWriter, r *http.Request, start time.Time) {
+	status := sw.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	// routes without a pattern are labeled together, paths would make a
+	// series for every id
+	route := rt.pattern
+	if route == "" {
+		route = "unknown"
+	}
+
+	code := strconv.Itoa(status)
+	requestsTotal.Inc(r.Method, route, code)
+	requestDuration.Since(start, r.Method, route, code)
+}
@@ -0,0 +1,124 @@
+package httptools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// a router with a logged route at pattern answering with handler, access
+// log lines are written to the returned buffer
+func logRouter(t *testing.T, pattern string, handler RouteHandle) (*httprouter.Router, *bytes.Buffer) {
+	t.Helper()
+	var log bytes.Buffer
+	prev := AccessLog
+	AccessLog = &log
+	t.Cleanup(func() { AccessLog = prev })
+
+	router := httprouter.New()
+	RouteNew().Log().Register(router, http.MethodGet, pattern, handler)
+	return router, &log
+}
+
+func ok(context.Context, http.ResponseWriter, *http.Request, httprouter.Params) (int, error) {
+	return http.StatusOK, nil
+}
+
+func TestRecoverPanic(t *testing.T) {
+	router, log := logRouter(t, "/boom", func(context.Context, http.ResponseWriter, *http.Request, httprouter.Params) (int, error) {
+		panic("boom")
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/boom", nil))
+
+	var p Problem
+	if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+		t.Fatalf("invalid problem %s", w.Body)
+	}
+	if w.Code != http.StatusInternalServerError || p.Code != "internal_error" || p.Detail != "internal error" {
+		t.Errorf("got %d %q %q, want 500 internal_error without the panic", w.Code, p.Code, p.Detail)
+	}
+
+	var line accessLine
+	if err := json.Unmarshal(log.Bytes(), &line); err != nil {
+		t.Fatalf("invalid access log %s", log)
+	}
+	if line.Status != http.StatusInternalServerError || !line.Panic {
+		t.Errorf("got access log %+v, want a 500 that panicked", line)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	router, _ := logRouter(t, "/ok", ok)
+
+	tests := []struct {
+		name string
+		id   string
+		keep bool
+	}{
+		{"caller's id", "6f1c2d3e-aaaa-bbbb-cccc-000000000000", true},
+		{"id with separators", "trace.span:1_2", true},
+		{"128 characters", strings.Repeat("a", 128), true},
+		{"no id", "", false},
+		{"too long", strings.Repeat("a", 129), false},
+		{"spaces", "a b", false},
+		{"newline", "abc\nERROR/panic forged", false},
+		{"quotes", `abc"def`, false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/ok", nil)
+		if tt.id != "" {
+			r.Header["X-Request-Id"] = []string{tt.id}
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+
+		got := w.Header().Get("X-Request-ID")
+		if tt.keep && got != tt.id {
+			t.Errorf("%s: got %q, want the caller's id echoed", tt.name, got)
+		}
+		if !tt.keep && (got == tt.id || len(got) != 36) {
+			t.Errorf("%s: got %q, want a generated uuid", tt.name, got)
+		}
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	router, log := logRouter(t, "/quotes/:id", func(_ context.Context, _ http.ResponseWriter, r *http.Request, _ httprouter.Params) (int, error) {
+		SetUser(r, "200000000000000001")
+		return http.StatusNotFound, NotFound("no quote")
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/quotes/abc", nil)
+	r.Header.Set("X-Request-ID", "req-1")
+	r.Header.Set("User-Agent", "steno-test")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+
+	var line accessLine
+	if err := json.Unmarshal(log.Bytes(), &line); err != nil {
+		t.Fatalf("invalid access log %s", log)
+	}
+	if line.RequestID != "req-1" || line.Method != http.MethodGet || line.Route != "/quotes/:id" ||
+		line.Path != "/quotes/abc" || line.Status != http.StatusNotFound || line.Bytes != w.Body.Len() ||
+		line.User != "200000000000000001" || line.UserAgent != "steno-test" || line.Panic {
+		t.Errorf("got access log %+v", line)
+	}
+	if strings.Count(log.String(), "\n") != 1 {
+		t.Errorf("got %q, want one line per request", log)
+	}
+
+	// anonymous requests leave the user out
+	router, log = logRouter(t, "/ok", ok)
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ok", nil))
+	if strings.Contains(log.String(), `"user"`) {
+		t.Errorf("got %s, want no user", log)
+	}
+}
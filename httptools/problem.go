@@ -0,0 +1,198 @@
+package httptools
+
+// errors returned from a RouteHandle are written as rfc 7807 problem details
+//
+//	{
+//	  "type": "about:blank",
+//	  "title": "Not Found",
+//	  "status": 404,
+//	  "code": "not_found",
+//	  "detail": "no quote with id/abc",
+//	  "instance": "/quotes/1/2/abc",
+//	  "request_id": "6f1c...",
+//	  "fields": {"author_id": "is not a discord user id"}
+//	}
+//
+// code is stable for clients to switch on, detail is for people
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Problem is an error with everything needed to render it as a problem
+// details response, handlers can return one to pick the code clients see
+type Problem struct {
+	Type      string      `json:"type"`
+	Title     string      `json:"title"`
+	Status    int         `json:"status"`
+	Code      string      `json:"code"`
+	Detail    string      `json:"detail,omitempty"`
+	Instance  string      `json:"instance,omitempty"`
+	RequestID string      `json:"request_id,omitempty"`
+	Fields    FieldErrors `json:"fields,omitempty"`
+
+	// the underlying error, logged but not sent
+	err error
+}
+
+// ProblemNew returns a problem with a status and code, detail is formatted like fmt.Sprintf
+func ProblemNew(status int, code, format string, a ...interface{}) *Problem {
+	return &Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Code:   code,
+		Detail: fmt.Sprintf(format, a...),
+	}
+}
+
+func (p *Problem) Error() string {
+	if p.err != nil {
+		return fmt.Sprintf("%s/%s", p.Detail, p.err)
+	}
+	if p.Detail == "" {
+		return p.Code
+	}
+	return p.Detail
+}
+
+func (p *Problem) Unwrap() error {
+	return p.err
+}
+
+// NotFound is a 404 for a resource that doesn't exist
+func NotFound(format string, a ...interface{}) *Problem {
+	return ProblemNew(http.StatusNotFound, "not_found", format, a...)
+}
+
+// Forbidden is a 403 for a user that isn't allowed to do what they asked
+func Forbidden(format string, a ...interface{}) *Problem {
+	return ProblemNew(http.StatusForbidden, "forbidden", format, a...)
+}
+
+// Validation is a 422 listing what is wrong with each invalid field
+func Validation(fields FieldErrors) *Problem {
+	p := ProblemNew(http.StatusUnprocessableEntity, "validation_failed", "%s", fields.Error())
+	p.Fields = fields
+	return p
+}
+
+// Unavailable is a 503 for a request that can't be answered right now but
+// could be if retried later
+func Unavailable(format string, a ...interface{}) *Problem {
+	return ProblemNew(http.StatusServiceUnavailable, "unavailable", format, a...)
+}
+
+// Timeout is a 504 for a request that ran out of time waiting on a service
+// we depend on
+func Timeout() *Problem {
+	return ProblemNew(http.StatusGatewayTimeout, "timeout", "request timed out")
+}
+
+// Upstream is a 502 for a failed request to a service we depend on, err
+// is logged but not sent to the client
+func Upstream(service string, err error) *Problem {
+	p := ProblemNew(http.StatusBadGateway, "upstream_error", "%s request failed", service)
+	p.err = err
+	return p
+}
+
+// the code of errors returned without a Problem e.g. "bad_request" for 400
+func statusCode(status int) string {
+	text := http.StatusText(status)
+	if text == "" {
+		return "error"
+	}
+	return strings.ReplaceAll(strings.ToLower(text), " ", "_")
+}
+
+// convert any error returned by a RouteHandle to a Problem, ctx is the
+// context the handler ran with
+func problemFrom(ctx context.Context, status int, err error) *Problem {
+	var p *Problem
+	isProblem := errors.As(err, &p)
+
+	// handlers format most errors with %s so ctx is checked as well, unless
+	// the handler picked a problem itself
+	switch {
+	case errors.Is(err, context.DeadlineExceeded) ||
+		!isProblem && ctx.Err() == context.DeadlineExceeded:
+		p = Timeout()
+		p.err = err
+		return p
+	case errors.Is(err, context.Canceled) ||
+		!isProblem && ctx.Err() == context.Canceled:
+		// the client went away, this is only seen in the logs
+		p = Unavailable("request canceled")
+		p.err = err
+		return p
+	}
+
+	if isProblem {
+		out := *p
+		return &out
+	}
+
+	var fields FieldErrors
+	if errors.As(err, &fields) {
+		return Validation(fields)
+	}
+
+	// an error with a success status is a bug in the handler
+	if status < 400 {
+		status = http.StatusInternalServerError
+	}
+	if status >= 500 {
+		// internal errors can carry store keys or what a dependency answered,
+		// they are logged and the client only gets the status
+		p = ProblemNew(status, statusCode(status), "%s", strings.ToLower(http.StatusText(status)))
+		p.err = err
+		return p
+	}
+	return ProblemNew(status, statusCode(status), "%s", err.Error())
+}
+
+// WriteProblem writes p as an application/problem+json response
+func WriteProblem(w http.ResponseWriter, p *Problem) {
+	body, err := json.Marshal(p)
+	if err != nil {
+		http.Error(w, p.Error(), p.Status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	w.Write(body)
+}
+
+type requestIDKey struct{}
+
+// RequestID returns the id of the request, taken from the X-Request-ID
+// header or generated when the route started handling it
+func RequestID(r *http.Request) string {
+	id, _ := Value(r, requestIDKey{}).(string)
+	return id
+}
+
+// keep the caller's request id so a request can be followed across services,
+// ids that could mess up the logs are replaced
+func newRequestID(r *http.Request) string {
+	id := r.Header.Get("X-Request-ID")
+	if id == "" || len(id) > 128 {
+		return uuid.NewString()
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("-_.:", c)) {
+			return uuid.NewString()
+		}
+	}
+	return id
+}
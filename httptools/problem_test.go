@@ -0,0 +1,97 @@
+package httptools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestProblemFrom(t *testing.T) {
+	expired, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	ctx := context.Background()
+
+	secret := errors.New("redis GET quote:1:abc failed")
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		status int
+		err    error
+		want   int
+		code   string
+		detail string
+	}{
+		{"client error", ctx, 400, errors.New("invalid request, bad json"), 400, "bad_request", "invalid request, bad json"},
+		{"chosen problem", ctx, 404, NotFound("no quote with id/%s", "abc"), 404, "not_found", "no quote with id/abc"},
+		{"field errors", ctx, 400, FieldErrors{"str": "is required"}, 422, "validation_failed", "invalid fields, str is required"},
+		{"internal error", ctx, 500, secret, 500, "internal_server_error", "internal server error"},
+		{"wrapped internal error", ctx, 502, fmt.Errorf("upstream/%w", secret), 502, "bad_gateway", "bad gateway"},
+		{"error with a success status", ctx, 200, secret, 500, "internal_server_error", "internal server error"},
+		{"deadline exceeded", ctx, 500, fmt.Errorf("get failed/%w", context.DeadlineExceeded), 504, "timeout", "request timed out"},
+		{"handler ran out of time", expired, 500, secret, 504, "timeout", "request timed out"},
+		{"canceled", ctx, 500, fmt.Errorf("get failed/%w", context.Canceled), 503, "unavailable", "request canceled"},
+		{"client went away", canceled, 500, secret, 503, "unavailable", "request canceled"},
+		{"problem picked after the deadline", expired, 403, Forbidden("not yours"), 403, "forbidden", "not yours"},
+	}
+	for _, tt := range tests {
+		p := problemFrom(tt.ctx, tt.status, tt.err)
+		if p.Status != tt.want || p.Code != tt.code || p.Detail != tt.detail {
+			t.Errorf("%s: got %d %q %q, want %d %q %q", tt.name, p.Status, p.Code, p.Detail, tt.want, tt.code, tt.detail)
+		}
+		if p.Title != http.StatusText(tt.want) {
+			t.Errorf("%s: got title %q, want %q", tt.name, p.Title, http.StatusText(tt.want))
+		}
+	}
+
+	// the internal error is kept for the logs
+	if p := problemFrom(ctx, 500, secret); !errors.Is(p, secret) {
+		t.Errorf("internal error: got %v, want it to wrap the handler's error", p)
+	}
+	if p := problemFrom(ctx, 400, FieldErrors{"str": "is required"}); p.Fields["str"] != "is required" {
+		t.Errorf("field errors: got fields %v, want str", p.Fields)
+	}
+}
+
+func TestWriteProblem(t *testing.T) {
+	router := httprouter.New()
+	RouteNew().Register(router, http.MethodGet, "/quotes/:id", func(context.Context, http.ResponseWriter, *http.Request, httprouter.Params) (int, error) {
+		return http.StatusNotFound, NotFound("no quote with id/%s", "abc")
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/quotes/abc", nil)
+	r.Header.Set("X-Request-ID", "req-1")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+
+	if ct := w.Header().Get("Content-Type"); ct != "application/problem+json" {
+		t.Errorf("got content type %q, want application/problem+json", ct)
+	}
+	if nosniff := w.Header().Get("X-Content-Type-Options"); nosniff != "nosniff" {
+		t.Errorf("got X-Content-Type-Options %q, want nosniff", nosniff)
+	}
+
+	var p Problem
+	if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+		t.Fatalf("invalid problem %s", w.Body)
+	}
+	want := Problem{
+		Type:      "about:blank",
+		Title:     "Not Found",
+		Status:    404,
+		Code:      "not_found",
+		Detail:    "no quote with id/abc",
+		Instance:  "/quotes/abc",
+		RequestID: "req-1",
+	}
+	if w.Code != 404 || fmt.Sprint(p) != fmt.Sprint(want) {
+		t.Errorf("got %d %+v, want 404 %+v", w.Code, p, want)
+	}
+}
@@ -0,0 +1,487 @@
+package main
+
+// discord slash commands, discord posts interactions to /interactions
+// signed with the application's key and we answer with the message to show
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+
+	"steno/discord"
+	"steno/httptools"
+	"steno/quotestore"
+)
+
+// the application's public key from the developer portal, /interactions is
+// only served when it's set
+var interactionKey ed25519.PublicKey
+
+const embedColor = 0x5865f2
+
+// most results shown for /quote search
+const maxSearchEmbeds = 5
+
+// the shortest a search result's quote is cut to
+const minSearchQuote = 200
+
+// name of the message context menu command that saves the message as a quote
+const saveQuoteCommand = "Save as quote"
+
+// the commands steno answers
+var applicationCommands = []discord.ApplicationCommand{
+	{
+		Type:        discord.CommandChatInput,
+		Name:        "quote",
+		Description: "Save and recall quotes",
+		Options: []discord.ApplicationCommandOption{
+			{
+				Type:        discord.OptionSubCommand,
+				Name:        "add",
+				Description: "Save a quote",
+				Options: []discord.ApplicationCommandOption{
+					{Type: discord.OptionUser, Name: "user", Description: "Who said it", Required: true},
+					{Type: discord.OptionString, Name: "text", Description: "What they said", Required: true},
+				},
+			},
+			{
+				Type:        discord.OptionSubCommand,
+				Name:        "random",
+				Description: "Show a random quote",
+				Options: []discord.ApplicationCommandOption{
+					{Type: discord.OptionUser, Name: "user", Description: "Only quotes by this user"},
+				},
+			},
+			{
+				Type:        discord.OptionSubCommand,
+				Name:        "search",
+				Description: "Search quotes",
+				Options: []discord.ApplicationCommandOption{
+					{Type: discord.OptionString, Name: "query", Description: "Words, author:, tag:, before:, after: or re:", Required: true},
+					{Type: discord.OptionUser, Name: "user", Description: "Only quotes by this user"},
+				},
+			},
+			{
+				Type:        discord.OptionSubCommand,
+				Name:        "delete",
+				Description: "Delete a quote",
+				Options: []discord.ApplicationCommandOption{
+					{Type: discord.OptionUser, Name: "user", Description: "Who said it", Required: true},
+					{Type: discord.OptionString, Name: "id", Description: "The quote's id, shown under it", Required: true},
+				},
+			},
+		},
+	},
+	{
+		Type: discord.CommandMessage,
+		Name: saveQuoteCommand,
+	},
+}
+
+type interactionBodyKey struct{}
+
+/** Handler for checking an interaction was sent by discord
+ *
+ *  @header X-Signature-Ed25519 hex signature of the timestamp and body
+ *  @header X-Signature-Timestamp
+ */
+func verifyInteraction(_ context.Context, _ http.ResponseWriter, r *http.Request, _ httprouter.Params) (int, error) {
+	body, err := discord.VerifyInteraction(r, interactionKey)
+	r.Body.Close()
+	if errors.Is(err, discord.ErrInteractionTooLarge) {
+		return http.StatusRequestEntityTooLarge, fmt.Errorf("invalid request, %s", err)
+	} else if err != nil {
+		return http.StatusUnauthorized, fmt.Errorf("invalid request, %s", err)
+	}
+
+	httptools.SetValue(r, interactionBodyKey{}, body)
+	return http.StatusOK, nil
+}
+
+/**
+ * Handler for discord interactions, answers PINGs and /quote commands
+ *
+ * @body json interaction object
+ */
+func handleInteraction(ctx context.Context, w http.ResponseWriter, r *http.Request, _ httprouter.Params) (int, error) {
+	body, _ := httptools.Value(r, interactionBodyKey{}).([]byte)
+	var interaction discord.Interaction
+	err := json.Unmarshal(body, &interaction)
+	if err != nil {
+		return http.StatusBadRequest, fmt.Errorf("invalid request, %s", err)
+	}
+
+	var resp discord.InteractionResponse
+	switch interaction.Type {
+	case discord.InteractionPing:
+		resp = discord.InteractionResponse{Type: discord.CallbackPong}
+	case discord.InteractionApplicationCommand:
+		resp = discord.InteractionResponse{
+			Type: discord.CallbackChannelMessageWithSource,
+			Data: runCommand(ctx, r, interaction),
+		}
+	default:
+		return http.StatusBadRequest, fmt.Errorf("invalid request, unsupported interaction type %d", interaction.Type)
+	}
+
+	respJSON, err := json.Marshal(resp)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("json marshal failed/%s", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, string(respJSON))
+	return http.StatusOK, nil
+}
+
+// a subcommand of /quote run in guildID, errors are logged and the invoking
+// user is told to try again, anything the user should see is returned as the reply
+type commandHandle func(ctx context.Context, r *http.Request, interaction discord.Interaction,
+	guildID discord.Snowflake, opts map[string]interface{}) (*discord.InteractionApplicationCommandCallbackData, error)
+
+var quoteCommands = map[string]commandHandle{
+	"add":    quoteAddCommand,
+	"random": quoteRandomCommand,
+	"search": quoteSearchCommand,
+	"delete": quoteDeleteCommand,
+}
+
+// reply only the invoking user can see
+func ephemeral(content string) *discord.InteractionApplicationCommandCallbackData {
+	return &discord.InteractionApplicationCommandCallbackData{
+		Content:         content,
+		Flags:           discord.MessageFlagEphemeral,
+		AllowedMentions: &discord.AllowedMentions{Parse: []string{}},
+	}
+}
+
+func embeds(content string, embeds ...discord.Embed) *discord.InteractionApplicationCommandCallbackData {
+	return &discord.InteractionApplicationCommandCallbackData{
+		Content:         content,
+		Embeds:          embeds,
+		AllowedMentions: &discord.AllowedMentions{Parse: []string{}},
+	}
+}
+
+// cut s to at most n characters, marking the cut with an ellipsis
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	if n <= 0 {
+		return ""
+	}
+	return string([]rune(s)[:n-1]) + "…"
+}
+
+// the quote's text and who said it, the text is cut short to keep the
+// description at most n characters
+func quoteDescription(q quotestore.Quote, n int) string {
+	attribution := fmt.Sprintf("\n\n— <@%s>", q.AuthorID)
+	return truncate(q.Str, n-utf8.RuneCountInString(attribution)) + attribution
+}
+
+func quoteEmbed(guildID discord.Snowflake, q quotestore.Quote) discord.Embed {
+	embed := discord.Embed{
+		Description: quoteDescription(q, discord.EmbedDescriptionLimit),
+		Color:       embedColor,
+		Footer:      &discord.EmbedFooter{Text: "id " + q.ID},
+	}
+	// discord rejects the whole response over a bad timestamp
+	if _, err := time.Parse(time.RFC3339, q.Date); err == nil {
+		embed.Timestamp = q.Date
+	}
+	if q.StenographerID != "" {
+		embed.Fields = append(embed.Fields, discord.EmbedField{
+			Name: "Recorded by", Value: fmt.Sprintf("<@%s>", q.StenographerID), Inline: true,
+		})
+	}
+	if len(q.Tags) > 0 {
+		embed.Fields = append(embed.Fields, discord.EmbedField{
+			Name: "Tags", Value: truncate(strings.Join(q.Tags, ", "), discord.EmbedFieldValueLimit), Inline: true,
+		})
+	}
+	if url := q.JumpURL(guildID); url != "" {
+		embed.Fields = append(embed.Fields, discord.EmbedField{
+			Name: "Source", Value: fmt.Sprintf("[Jump to message](%s)", url), Inline: true,
+		})
+	}
+	return embed
+}
+
+// flatten a subcommand's options to name -> value
+func commandOptions(opts []discord.ApplicationCommandInteractionDataOption) map[string]interface{} {
+	out := make(map[string]interface{}, len(opts))
+	for _, opt := range opts {
+		out[opt.Name] = opt.Value
+	}
+	return out
+}
+
+func stringOption(opts map[string]interface{}, name string) string {
+	s, _ := opts[name].(string)
+	return s
+}
+
+// the id of a user option, zero if the option wasn't given
+func userOption(opts map[string]interface{}, name string) (discord.Snowflake, error) {
+	s := stringOption(opts, name)
+	if s == "" {
+		return 0, nil
+	}
+	return discord.ParseSnowflake(s)
+}
+
+// run a command as the member that invoked it
+func runCommand(ctx context.Context, r *http.Request, interaction discord.Interaction) *discord.InteractionApplicationCommandCallbackData {
+	data := interaction.Data
+	if data == nil {
+		return ephemeral("Unknown command.")
+	}
+	guildID, err := discord.ParseSnowflake(interaction.GuildID)
+	if err != nil || interaction.Member == nil {
+		return ephemeral("Quotes can only be used in a server.")
+	}
+
+	var name string
+	var handler commandHandle
+	var opts map[string]interface{}
+	switch {
+	case data.Type == discord.CommandMessage && data.Name == saveQuoteCommand:
+		name, handler = saveQuoteCommand, saveMessageCommand
+	case data.Name == "quote" && len(data.Options) > 0:
+		name, handler = "/quote "+data.Options[0].Name, quoteCommands[data.Options[0].Name]
+		opts = commandOptions(data.Options[0].Options)
+	}
+	if handler == nil {
+		return ephemeral("Unknown command.")
+	}
+
+	// discord sends the member's permissions in the channel with the interaction
+	perms, err := discord.ParsePermissions(interaction.Member.Permissions)
+	if err != nil {
+		log.Printf("ERROR: interaction permissions parsing failed %s", err)
+	}
+	httptools.SetUser(r, interaction.Member.User.ID)
+	httptools.SetValue(r, identityKey{}, identity{
+		User:        interaction.Member.User,
+		TokenType:   "Interaction",
+		Permissions: perms,
+	})
+
+	msg, err := handler(ctx, r, interaction, guildID, opts)
+	if err != nil {
+		log.Printf("ERROR: interaction %s failed %s", name, err)
+		return ephemeral("Something went wrong, try again later.")
+	}
+	return msg
+}
+
+func quoteAddCommand(ctx context.Context, _ *http.Request, interaction discord.Interaction,
+	guildID discord.Snowflake, opts map[string]interface{}) (*discord.InteractionApplicationCommandCallbackData, error) {
+	authorID, err := userOption(opts, "user")
+	quote := quotestore.Quote{
+		ID:             uuid.NewString(),
+		AuthorID:       authorID.String(),
+		Str:            stringOption(opts, "text"),
+		Date:           time.Now().UTC().Format(time.RFC3339),
+		StenographerID: interaction.Invoker().ID,
+	}
+	if err != nil || authorID == 0 || strings.TrimSpace(quote.Str) == "" {
+		return ephemeral("A quote needs a user and some text."), nil
+	}
+	if reply, err := validateCommandAuthor(ctx, guildID, authorID); reply != nil || err != nil {
+		return reply, err
+	}
+
+	err = stenoStore.Push(ctx, guildID, authorID, quote)
+	if err != nil {
+		return nil, fmt.Errorf("push quote failed/%s", err)
+	}
+	return embeds("Quote saved.", quoteEmbed(guildID, quote)), nil
+}
+
+// check who a quote is by the way the api checks author_id, the reply is
+// nil if they can be quoted
+func validateCommandAuthor(ctx context.Context, guildID, authorID discord.Snowflake) (*discord.InteractionApplicationCommandCallbackData, error) {
+	status, err := validateQuoteUsers(ctx, guildID, map[string]string{"author_id": authorID.String()})
+	if status == http.StatusUnprocessableEntity {
+		return ephemeral(fmt.Sprintf("<@%s> isn't a member of this server.", authorID)), nil
+	} else if err != nil {
+		return nil, fmt.Errorf("validate quote users failed/%s", err)
+	}
+	return nil, nil
+}
+
+func quoteRandomCommand(ctx context.Context, _ *http.Request, interaction discord.Interaction,
+	guildID discord.Snowflake, opts map[string]interface{}) (*discord.InteractionApplicationCommandCallbackData, error) {
+	userID, err := userOption(opts, "user")
+	if err != nil {
+		return ephemeral("Unknown user."), nil
+	}
+
+	var quotes []quotestore.Quote
+	if userID != 0 {
+		quotes, err = stenoStore.GetRandom(ctx, guildID, userID, 1)
+	} else {
+		quotes, err = stenoStore.GuildGetRandom(ctx, guildID, 1)
+	}
+	// the stores error when there are no quotes to pick from
+	if err != nil || len(quotes) == 0 {
+		return ephemeral("No quotes yet, add one with /quote add."), nil
+	}
+	return embeds("", quoteEmbed(guildID, quotes[0])), nil
+}
+
+func quoteSearchCommand(ctx context.Context, _ *http.Request, interaction discord.Interaction,
+	guildID discord.Snowflake, opts map[string]interface{}) (*discord.InteractionApplicationCommandCallbackData, error) {
+	query, err := quotestore.ParseQuery(stringOption(opts, "query"))
+	if err != nil {
+		return ephemeral(fmt.Sprintf("Bad search, %s.", strings.TrimPrefix(err.Error(), "invalid query, "))), nil
+	}
+	userID, err := userOption(opts, "user")
+	if err != nil {
+		return ephemeral("Unknown user."), nil
+	}
+
+	var results []quotestore.SearchResult
+	if userID != 0 {
+		results, err = stenoStore.Search(ctx, guildID, userID, query)
+	} else {
+		results, err = stenoStore.GuildSearch(ctx, guildID, query)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("search quotes failed/%s", err)
+	}
+	if len(results) == 0 {
+		return ephemeral(fmt.Sprintf("No quotes match %q.", query.Raw)), nil
+	}
+
+	shown := results
+	if len(shown) > maxSearchEmbeds {
+		shown = shown[:maxSearchEmbeds]
+	}
+	out := searchEmbeds(guildID, shown)
+
+	content := fmt.Sprintf("%d quotes match.", len(results))
+	if len(results) == 1 {
+		content = "1 quote matches."
+	} else if len(out) < len(results) {
+		content = fmt.Sprintf("%d quotes match, showing the top %d.", len(results), len(out))
+	}
+	return embeds(content, out...), nil
+}
+
+// the embeds of results that fit in one message, discord rejects messages
+// whose embeds together are longer than EmbedTotalLimit so long quotes are
+// cut short to share it and the results that don't fit are left out
+func searchEmbeds(guildID discord.Snowflake, results []quotestore.SearchResult) []discord.Embed {
+	out := make([]discord.Embed, 0, len(results))
+	left := discord.EmbedTotalLimit
+	for i, result := range results {
+		embed := quoteEmbed(guildID, result.Quote)
+		// short quotes leave more for the ones after them
+		share := left / (len(results) - i)
+		if n := embed.Length(); n > share {
+			room := share - (n - utf8.RuneCountInString(embed.Description))
+			if room < minSearchQuote {
+				room = minSearchQuote
+			}
+			embed.Description = quoteDescription(result.Quote, room)
+		}
+		if embed.Length() > left {
+			break
+		}
+		left -= embed.Length()
+		out = append(out, embed)
+	}
+	return out
+}
+
+func quoteDeleteCommand(ctx context.Context, r *http.Request, interaction discord.Interaction,
+	guildID discord.Snowflake, opts map[string]interface{}) (*discord.InteractionApplicationCommandCallbackData, error) {
+	quoteID := strings.TrimSpace(stringOption(opts, "id"))
+	userID, err := userOption(opts, "user")
+	if err != nil || userID == 0 {
+		return ephemeral("Unknown user."), nil
+	}
+	if !quotestore.IsQuoteID(quoteID) {
+		return ephemeral(fmt.Sprintf("<@%s> has no quote with id %s.", userID, quoteID)), nil
+	}
+
+	quote, err := stenoStore.GetByID(ctx, guildID, userID, quoteID)
+	if errors.Is(err, quotestore.ErrNotFound) {
+		return ephemeral(fmt.Sprintf("<@%s> has no quote with id %s.", userID, quoteID)), nil
+	} else if err != nil {
+		return nil, fmt.Errorf("get quote failed/%s", err)
+	}
+
+	if _, err := authorizeQuoteChange(r, quote, "delete"); err != nil {
+		return ephemeral("Only the quote's author, whoever recorded it or members " +
+			"with Manage Messages can delete it."), nil
+	}
+
+	err = stenoStore.RmByID(ctx, guildID, userID, quoteID)
+	if err != nil && !errors.Is(err, quotestore.ErrNotFound) {
+		return nil, fmt.Errorf("rm quote failed/%s", err)
+	}
+	return ephemeral("Quote deleted."), nil
+}
+
+// save the message the context menu was opened on, the message id doubles
+// as the quote id so a message can only be saved once
+func saveMessageCommand(ctx context.Context, _ *http.Request, interaction discord.Interaction,
+	guildID discord.Snowflake, _ map[string]interface{}) (*discord.InteractionApplicationCommandCallbackData, error) {
+	msg, ok := interaction.Data.TargetMessage()
+	if !ok {
+		return ephemeral("Couldn't find that message."), nil
+	}
+	if strings.TrimSpace(msg.Content) == "" {
+		return ephemeral("That message has no text to quote."), nil
+	}
+
+	// discord timestamps have microseconds, the date indexes are to the second
+	date := msg.Timestamp
+	if t, err := time.Parse(time.RFC3339, msg.Timestamp); err == nil {
+		date = t.UTC().Format(time.RFC3339)
+	}
+
+	channelID := msg.ChannelID
+	if channelID == "" {
+		channelID = interaction.ChannelID
+	}
+
+	quote := quotestore.Quote{
+		ID:             msg.ID,
+		AuthorID:       msg.Author.ID,
+		Str:            msg.Content,
+		Date:           date,
+		StenographerID: interaction.Invoker().ID,
+		MessageID:      msg.ID,
+		ChannelID:      channelID,
+	}
+	authorID, err := discord.ParseSnowflake(msg.Author.ID)
+	if err != nil {
+		return ephemeral("Couldn't find that message's author."), nil
+	}
+	if reply, err := validateCommandAuthor(ctx, guildID, authorID); reply != nil || err != nil {
+		return reply, err
+	}
+	err = stenoStore.Push(ctx, guildID, authorID, quote)
+	if errors.Is(err, quotestore.ErrDuplicateID) {
+		return ephemeral("That message is already saved as a quote."), nil
+	} else if err != nil {
+		return nil, fmt.Errorf("push quote failed/%s", err)
+	}
+	return embeds("Quote saved.", quoteEmbed(guildID, quote)), nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"steno/discord"
+	"steno/discord/discordtest"
+	"steno/quotestore"
+)
+
+// setup with /interactions served, the returned key signs interactions
+func setupInteractions(t *testing.T) (*discordtest.Server, ed25519.PrivateKey) {
+	t.Helper()
+	srv := setup(t)
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	interactionKey = pub
+	t.Cleanup(func() { interactionKey = nil })
+	return srv, priv
+}
+
+// post body to /interactions signed with priv the way discord does
+func postInteraction(t *testing.T, priv ed25519.PrivateKey, body []byte) (int, discord.InteractionResponse) {
+	t.Helper()
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	r := httptest.NewRequest(http.MethodPost, "/interactions", bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("X-Signature-Timestamp", timestamp)
+	r.Header.Set("X-Signature-Ed25519", hex.EncodeToString(ed25519.Sign(priv, append([]byte(timestamp), body...))))
+
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+
+	var resp discord.InteractionResponse
+	if w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("invalid interaction response %s", w.Body)
+		}
+	}
+	return w.Code, resp
+}
+
+// a /quote subcommand run by the member in the fixture guild, options are name, value pairs
+func quoteCommand(invokerID, perms, sub string, options ...string) discord.Interaction {
+	var opts []discord.ApplicationCommandInteractionDataOption
+	for i := 0; i+1 < len(options); i += 2 {
+		opts = append(opts, discord.ApplicationCommandInteractionDataOption{Name: options[i], Value: options[i+1]})
+	}
+	return discord.Interaction{
+		Type:    discord.InteractionApplicationCommand,
+		GuildID: discordtest.GuildID,
+		Member:  &discord.GuildMember{User: discord.User{ID: invokerID}, Permissions: perms},
+		Data: &discord.ApplicationCommandInteractionData{
+			Name: "quote",
+			Type: discord.CommandChatInput,
+			Options: []discord.ApplicationCommandInteractionDataOption{
+				{Name: sub, Type: discord.OptionSubCommand, Options: opts},
+			},
+		},
+	}
+}
+
+func TestInteractionRequests(t *testing.T) {
+	_, priv := setupInteractions(t)
+	_, otherPriv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ping := []byte(`{"type": 1}`)
+	tests := []struct {
+		name   string
+		key    ed25519.PrivateKey
+		body   []byte
+		status int
+		want   discord.InteractionCallbackType
+	}{
+		{"ping", priv, ping, 200, discord.CallbackPong},
+		{"signed by someone else", otherPriv, ping, 401, 0},
+		{"unknown type", priv, []byte(`{"type": 9}`), 400, 0},
+		{"not json", priv, []byte(`ping`), 400, 0},
+		{"too large", priv, []byte(`{"type": 1, "pad": "` + strings.Repeat("a", discord.MaxInteractionSize) + `"}`), 413, 0},
+	}
+	for _, tt := range tests {
+		status, resp := postInteraction(t, tt.key, tt.body)
+		if status != tt.status || resp.Type != tt.want {
+			t.Errorf("%s: got %d %d, want %d %d", tt.name, status, resp.Type, tt.status, tt.want)
+		}
+	}
+}
+
+func TestInteractionCommands(t *testing.T) {
+	_, priv := setupInteractions(t)
+	validateUsers = validateMember
+	botAuth = discordtest.BotToken
+	pushQuote(t, discordtest.MemberID, quotestore.Quote{ID: "q1", AuthorID: discordtest.MemberID,
+		Str: "the quick brown fox", Date: "2021-01-01T00:00:00Z"})
+	everyone := fmt.Sprint(uint64(discord.PermissionViewChannel))
+
+	noGuild := quoteCommand(discordtest.MemberID, everyone, "random")
+	noGuild.GuildID, noGuild.Member = "", nil
+	unknown := quoteCommand(discordtest.MemberID, everyone, "random")
+	unknown.Data.Name = "unquote"
+
+	tests := []struct {
+		name        string
+		interaction discord.Interaction
+		content     string
+		embeds      int
+	}{
+		{"add", quoteCommand(discordtest.ModID, everyone, "add", "user", discordtest.MemberID, "text", "hello"), "Quote saved.", 1},
+		{"add for an outsider", quoteCommand(discordtest.ModID, everyone, "add", "user", discordtest.OutsiderID, "text", "hello"),
+			"<@" + discordtest.OutsiderID + "> isn't a member of this server.", 0},
+		{"add without text", quoteCommand(discordtest.ModID, everyone, "add", "user", discordtest.MemberID), "A quote needs a user and some text.", 0},
+		{"random", quoteCommand(discordtest.ModID, everyone, "random", "user", discordtest.MemberID), "", 1},
+		{"search", quoteCommand(discordtest.ModID, everyone, "search", "query", "fox"), "1 quote matches.", 1},
+		{"search without results", quoteCommand(discordtest.ModID, everyone, "search", "query", "owl"), `No quotes match "owl".`, 0},
+		{"delete someone else's quote", quoteCommand(discordtest.ModID, everyone, "delete", "user", discordtest.MemberID, "id", "q1"),
+			"Only the quote's author, whoever recorded it or members with Manage Messages can delete it.", 0},
+		{"delete own quote", quoteCommand(discordtest.MemberID, everyone, "delete", "user", discordtest.MemberID, "id", "q1"), "Quote deleted.", 0},
+		{"outside a guild", noGuild, "Quotes can only be used in a server.", 0},
+		{"unknown command", unknown, "Unknown command.", 0},
+	}
+	for _, tt := range tests {
+		body, err := json.Marshal(tt.interaction)
+		if err != nil {
+			t.Fatal(err)
+		}
+		status, resp := postInteraction(t, priv, body)
+		if status != http.StatusOK || resp.Type != discord.CallbackChannelMessageWithSource || resp.Data == nil {
+			t.Errorf("%s: got %d %+v, want a message", tt.name, status, resp)
+			continue
+		}
+		if resp.Data.Content != tt.content || len(resp.Data.Embeds) != tt.embeds {
+			t.Errorf("%s: got %q with %d embeds, want %q with %d", tt.name, resp.Data.Content, len(resp.Data.Embeds), tt.content, tt.embeds)
+		}
+	}
+}
+
+func TestSearchEmbedsFit(t *testing.T) {
+	_, priv := setupInteractions(t)
+	for i := 0; i < 8; i++ {
+		pushQuote(t, discordtest.MemberID, quotestore.Quote{
+			ID:       fmt.Sprintf("q%d", i),
+			AuthorID: discordtest.MemberID,
+			Str:      "long " + strings.Repeat("blah ", 1000),
+			Tags:     []string{strings.Repeat("tag", 500)},
+		})
+	}
+
+	body, err := json.Marshal(quoteCommand(discordtest.MemberID, "0", "search", "query", "long"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, resp := postInteraction(t, priv, body)
+	if status != http.StatusOK || resp.Data == nil || len(resp.Data.Embeds) == 0 {
+		t.Fatalf("got %d %+v, want search results", status, resp.Data)
+	}
+
+	total := 0
+	for _, embed := range resp.Data.Embeds {
+		total += embed.Length()
+		if n := len([]rune(embed.Description)); n > discord.EmbedDescriptionLimit {
+			t.Errorf("got a %d character description, want at most %d", n, discord.EmbedDescriptionLimit)
+		}
+		for _, f := range embed.Fields {
+			if n := len([]rune(f.Value)); n > discord.EmbedFieldValueLimit {
+				t.Errorf("got a %d character %s field, want at most %d", n, f.Name, discord.EmbedFieldValueLimit)
+			}
+		}
+	}
+	if total > discord.EmbedTotalLimit {
+		t.Errorf("got embeds of %d characters, want at most %d", total, discord.EmbedTotalLimit)
+	}
+	want := fmt.Sprintf("8 quotes match, showing the top %d.", len(resp.Data.Embeds))
+	if resp.Data.Content != want {
+		t.Errorf("got %q, want %q", resp.Data.Content, want)
+	}
+}
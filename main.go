@@ -1,22 +1,24 @@
 package main
 
 import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"math"
 	"net/http"
-	"net/url"
 	"os"
-	"path"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 
 	"steno/discord"
+	"steno/guildcache"
 	"steno/httptools"
 	"steno/quotestore"
 )
@@ -30,20 +32,51 @@ func isJSONContent(contentType []string) bool {
 	return true
 }
 
-var stenoStore quotestore.RedisStore
-var httpClient *http.Client
+var stenoStore quotestore.QuoteStore
+var discordClient *discord.Client
+var guildAccess *guildcache.Loader
+
+// how author_id and stenographer_id are checked when quotes are added or edited
+type userValidation int
+
+const (
+	validateNone      userValidation = iota
+	validateSnowflake                // well formed discord ids
+	validateMember                   // well formed ids of members of the guild
+)
+
+var validateUsers userValidation
+var guildMembers *guildcache.MemberCache
+
+// the bot token used to look up guild members, Bearer tokens can't
+var botAuth string
+
+// the user id of steno's own bot, the only token trusted to act for other
+// members, a bot's user id is its application's id
+var stenoBotID discord.Snowflake
+
+// how long api requests have to answer before they fail with a 504
+var routeTimeout = 10 * time.Second
+
+// discord gives up on an interaction after 3 seconds, answering late is no
+// better than not answering
+const interactionTimeout = 2500 * time.Millisecond
+
+// quote ids from a request body are used in store keys just like route params
+var invalidQuoteID = httptools.FieldErrors{"id": "must be 1 to 64 letters, digits, - or _"}
 
 /** Handler for adding quotes to the store
  * @url_param guild_id
  * @url_param user_id
  *
  * @body json encoded quote object
+ *	stenographer_id defaults to the calling user for Bearer tokens
 NOTE:
  * Must set Content-Type header in order for the data to be read
 */
-func addQuotes(_ http.ResponseWriter, r *http.Request, ps httprouter.Params) (int, error) {
-	userID := ps.ByName("user_id")
-	guildID := ps.ByName("guild_id")
+func addQuotes(ctx context.Context, _ http.ResponseWriter, r *http.Request, _ httprouter.Params) (int, error) {
+	ids := requestIDs(r)
+	guildID, userID := ids.guild, ids.user
 	if !isJSONContent(r.Header["Content-Type"]) {
 		return http.StatusBadRequest, errors.New("expected json body")
 	}
@@ -52,15 +85,31 @@ func addQuotes(_ http.ResponseWriter, r *http.Request, ps httprouter.Params) (in
 	r.Body.Close()
 
 	if quote.AuthorID == "" {
-		quote.AuthorID = userID
+		quote.AuthorID = userID.String()
+	}
+
+	if user, ok := requestUser(r); ok && quote.StenographerID == "" {
+		quote.StenographerID = user.ID
 	}
 
 	if err != nil {
 		return http.StatusBadRequest, fmt.Errorf("invalid request, %s", err)
 	}
+	if !quotestore.IsQuoteID(quote.ID) {
+		return http.StatusUnprocessableEntity, httptools.Validation(invalidQuoteID)
+	}
 
-	err = stenoStore.Push(guildID, userID, quote)
-	if err != nil {
+	if status, err := validateQuoteUsers(ctx, guildID, map[string]string{
+		"author_id":       quote.AuthorID,
+		"stenographer_id": quote.StenographerID,
+	}); err != nil {
+		return status, err
+	}
+
+	err = stenoStore.Push(ctx, guildID, userID, quote)
+	if errors.Is(err, quotestore.ErrDuplicateID) {
+		return http.StatusConflict, httptools.ProblemNew(http.StatusConflict, "duplicate_id", "quote id already exists/%s", quote.ID)
+	} else if err != nil {
 		return http.StatusInternalServerError, fmt.Errorf("add quote failed/%s", err)
 	}
 
@@ -74,19 +123,33 @@ func addQuotes(_ http.ResponseWriter, r *http.Request, ps httprouter.Params) (in
  * @url_param user_id string
 NOTE:
  * redisstore requires the consumer of the api to provide json that will encode and then decode
- * and match with the json stored in the database, does not just match quote.ID which it probably
- * should
+ * and match with the json stored in the database, prefer
+ * DELETE /quotes/:guild_id/:user_id/:quote_id which only matches quote.ID
+ * only the quote's author or stenographer or members with MANAGE_MESSAGES may delete
 */
-func removeQuotes(_ http.ResponseWriter, r *http.Request, ps httprouter.Params) (int, error) {
-	userID := ps.ByName("user_id")
-	guildID := ps.ByName("guild_id")
+func removeQuotes(ctx context.Context, _ http.ResponseWriter, r *http.Request, _ httprouter.Params) (int, error) {
+	ids := requestIDs(r)
+	guildID, userID := ids.guild, ids.user
 	quote, err := quotestore.QuoteFromReader(r.Body)
 	r.Body.Close()
 	if err != nil {
 		return http.StatusBadRequest, fmt.Errorf("invalid request, %s", err)
 	}
+	if !quotestore.IsQuoteID(quote.ID) {
+		return http.StatusUnprocessableEntity, httptools.Validation(invalidQuoteID)
+	}
 
-	err = stenoStore.Rm(guildID, userID, quote)
+	// authorize against the stored quote, the body can claim any author
+	stored, err := stenoStore.GetByID(ctx, guildID, userID, quote.ID)
+	if err == nil {
+		if status, err := authorizeQuoteChange(r, stored, "delete"); err != nil {
+			return status, err
+		}
+	} else if !errors.Is(err, quotestore.ErrNotFound) {
+		return http.StatusInternalServerError, fmt.Errorf("get quote failed/%s", err)
+	}
+
+	err = stenoStore.Rm(ctx, guildID, userID, quote)
 	if err != nil {
 		return http.StatusInternalServerError, fmt.Errorf("rm quote failed/%s", err)
 	}
@@ -95,48 +158,225 @@ func removeQuotes(_ http.ResponseWriter, r *http.Request, ps httprouter.Params)
 }
 
 /**
- * Handler for the retriveing information about stored quotes
+ * Handler for removing a single quote from the store by its id
+ *
  * @url_param guild_id string
  * @url_param user_id string
+ * @url_param quote_id string
  *
- * @query_params search string a string to search the users quotes for
- * @query_params limit uint limit the amount of results that can be returned default 100
- * @query_params random bool only return one random quote if true
+ * only the quote's author or stenographer or members with MANAGE_MESSAGES may delete
+ */
+func removeQuoteByID(ctx context.Context, _ http.ResponseWriter, r *http.Request, ps httprouter.Params) (int, error) {
+	ids := requestIDs(r)
+	guildID, userID := ids.guild, ids.user
+	quoteID := ps.ByName("quote_id")
+
+	stored, err := stenoStore.GetByID(ctx, guildID, userID, quoteID)
+	if errors.Is(err, quotestore.ErrNotFound) {
+		return http.StatusNotFound, httptools.NotFound("no quote with id/%s", quoteID)
+	} else if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("get quote failed/%s", err)
+	}
+	if status, err := authorizeQuoteChange(r, stored, "delete"); err != nil {
+		return status, err
+	}
+
+	err = stenoStore.RmByID(ctx, guildID, userID, quoteID)
+	if errors.Is(err, quotestore.ErrNotFound) {
+		return http.StatusNotFound, httptools.NotFound("no quote with id/%s", quoteID)
+	} else if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("rm quote failed/%s", err)
+	}
+
+	return http.StatusOK, nil
+}
+
+/**
+ * Handler for retrieving a single quote by its id
+ *
+ * @url_param guild_id string
+ * @url_param user_id string
+ * @url_param quote_id string
  */
-func getQuotesForUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params) (int, error) {
-	userID := ps.ByName("user_id")
-	guildID := ps.ByName("guild_id")
+func getQuote(ctx context.Context, w http.ResponseWriter, r *http.Request, ps httprouter.Params) (int, error) {
+	ids := requestIDs(r)
+	guildID, userID := ids.guild, ids.user
+	quoteID := ps.ByName("quote_id")
+
+	quote, err := stenoStore.GetByID(ctx, guildID, userID, quoteID)
+	if errors.Is(err, quotestore.ErrNotFound) {
+		return http.StatusNotFound, httptools.NotFound("no quote with id/%s", quoteID)
+	} else if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("get quote failed/%s", err)
+	}
 
-	random := strings.Compare(strings.ToLower(r.FormValue("random")), "true") == 0
-	limit, err := strconv.Atoi(r.FormValue("limit"))
+	quoteJSON, err := json.Marshal(quote)
 	if err != nil {
-		limit = 100
+		return http.StatusInternalServerError, fmt.Errorf("json marshal failed/%s", err)
 	}
 
-	searchStr := r.FormValue("search")
+	fmt.Fprint(w, string(quoteJSON))
+	return http.StatusOK, nil
+}
 
-	var quotes []quotestore.Quote
-	if len(searchStr) > 0 {
-		quotes, err = stenoStore.Search(guildID, userID, searchStr)
-	} else if random {
-		var quote quotestore.Quote
-		quote, err = stenoStore.GetRandom(guildID, userID)
-		quotes = []quotestore.Quote{quote}
-	} else {
-		quotes, err = stenoStore.GetAll(guildID, userID)
+/**
+ * Handler for editing a quote in place, the previous text is kept as a revision
+ *
+ * @url_param guild_id string
+ * @url_param user_id string
+ * @url_param quote_id string
+ *
+ * @body json object with any of str, author_id, date, stenographer_id, tags
+ *	and the editor_id of the user making the edit, editor_id is taken from the
+ *	token for Bearer tokens
+NOTE:
+ * Must set Content-Type header in order for the data to be read
+ * only the quote's author or stenographer or members with MANAGE_MESSAGES may edit
+*/
+func editQuote(ctx context.Context, w http.ResponseWriter, r *http.Request, ps httprouter.Params) (int, error) {
+	ids := requestIDs(r)
+	guildID, userID := ids.guild, ids.user
+	quoteID := ps.ByName("quote_id")
+	if !isJSONContent(r.Header["Content-Type"]) {
+		return http.StatusBadRequest, errors.New("expected json body")
 	}
 
-	limit = int(math.Min(float64(len(quotes)), float64(limit)))
-	quotes = quotes[0:limit]
+	patch, err := quotestore.QuotePatchFromReader(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return http.StatusBadRequest, fmt.Errorf("invalid request, %s", err)
+	}
 
+	// users editing with their own token can't claim to be someone else
+	if user, ok := requestUser(r); ok {
+		patch.EditorID = user.ID
+	}
+
+	users := make(map[string]string)
+	if patch.AuthorID != nil {
+		users["author_id"] = *patch.AuthorID
+	}
+	if patch.StenographerID != nil {
+		users["stenographer_id"] = *patch.StenographerID
+	}
+	if status, err := validateQuoteUsers(ctx, guildID, users); err != nil {
+		return status, err
+	}
+
+	stored, err := stenoStore.GetByID(ctx, guildID, userID, quoteID)
+	if errors.Is(err, quotestore.ErrNotFound) {
+		return http.StatusNotFound, httptools.NotFound("no quote with id/%s", quoteID)
+	} else if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("get quote failed/%s", err)
+	}
+	if status, err := authorizeQuoteChange(r, stored, "edit"); err != nil {
+		return status, err
+	}
+
+	quote, err := stenoStore.Edit(ctx, guildID, userID, quoteID, patch)
+	if errors.Is(err, quotestore.ErrNotFound) {
+		return http.StatusNotFound, httptools.NotFound("no quote with id/%s", quoteID)
+	} else if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("edit quote failed/%s", err)
+	}
+
+	quoteJSON, err := json.Marshal(quote)
 	if err != nil {
-		return http.StatusInternalServerError, fmt.Errorf("get quotes failed/%s", err)
+		return http.StatusInternalServerError, fmt.Errorf("json marshal failed/%s", err)
 	}
 
-	if len(quotes) <= 0 {
-		return http.StatusNotFound, fmt.Errorf("no quotes for user/%s", userID)
+	fmt.Fprint(w, string(quoteJSON))
+	return http.StatusOK, nil
+}
+
+/**
+ * Handler for listing the edit history of a quote, oldest first
+ *
+ * @url_param guild_id string
+ * @url_param user_id string
+ * @url_param quote_id string
+ */
+func getRevisions(ctx context.Context, w http.ResponseWriter, r *http.Request, ps httprouter.Params) (int, error) {
+	ids := requestIDs(r)
+	guildID, userID := ids.guild, ids.user
+	quoteID := ps.ByName("quote_id")
+
+	revs, err := stenoStore.Revisions(ctx, guildID, userID, quoteID)
+	if errors.Is(err, quotestore.ErrNotFound) {
+		return http.StatusNotFound, httptools.NotFound("no quote with id/%s", quoteID)
+	} else if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("get revisions failed/%s", err)
 	}
 
+	revsJSON, err := json.Marshal(revs)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("json marshal failed/%s", err)
+	}
+
+	fmt.Fprint(w, string(revsJSON))
+	return http.StatusOK, nil
+}
+
+// query parameters shared by the quote listing handlers
+type quotesQuery struct {
+	search quotestore.Query
+	random bool
+	limit  int
+	count  int
+	page   quotestore.PageOptions
+}
+
+func parseQuotesQuery(r *http.Request) (quotesQuery, error) {
+	q := quotesQuery{
+		random: strings.Compare(strings.ToLower(r.FormValue("random")), "true") == 0,
+	}
+
+	var err error
+	if searchStr := r.FormValue("search"); len(searchStr) > 0 {
+		q.search, err = quotestore.ParseQuery(searchStr)
+		if err != nil {
+			return q, err
+		}
+		q.search.Fuzzy = strings.ToLower(r.FormValue("fuzzy")) == "true"
+	}
+
+	// searching, random picks and pages all cap results with the same limit
+	q.limit = quotestore.DefaultPageLimit
+	if limitStr := r.FormValue("limit"); limitStr != "" {
+		q.limit, err = strconv.Atoi(limitStr)
+		if err != nil || q.limit < 1 {
+			return q, errors.New("limit must be a positive integer")
+		}
+	}
+
+	q.count = 1
+	if countStr := r.FormValue("count"); countStr != "" {
+		q.count, err = strconv.Atoi(countStr)
+		if err != nil || q.count < 1 {
+			return q, errors.New("count must be a positive integer")
+		}
+	}
+	// picking more than the limit would only load quotes to throw them away
+	if q.count > q.limit {
+		q.count = q.limit
+	}
+
+	q.page = quotestore.PageOptions{
+		Cursor: r.FormValue("cursor"),
+		Limit:  q.limit,
+		Sort:   strings.ToLower(r.FormValue("sort")),
+	}
+	if q.page.Sort != "" && q.page.Sort != quotestore.SortAsc && q.page.Sort != quotestore.SortDesc {
+		return q, fmt.Errorf("sort must be %s or %s", quotestore.SortAsc, quotestore.SortDesc)
+	}
+
+	return q, nil
+}
+
+func writeQuotes(w http.ResponseWriter, quotes []quotestore.Quote, limit int) (int, error) {
+	limit = int(math.Min(float64(len(quotes)), float64(limit)))
+	quotes = quotes[0:limit]
+
 	quotesJSON, err := json.Marshal(quotes)
 	if err != nil {
 		return http.StatusInternalServerError, fmt.Errorf("json marshal failed/%s", err)
@@ -146,114 +386,590 @@ func getQuotesForUser(w http.ResponseWriter, r *http.Request, ps httprouter.Para
 	return http.StatusOK, nil
 }
 
-/** Wrapper function for making a request to the discord api
- *  @param method http.Method
- *  @param endpoint string discord api endpoint to request
- *  @param auth discord Authorization header
-		of the form "Bearer {token}" or "Bot {token}"
-*/
-func discordRequest(method, endpoint, auth string) ([]byte, error) {
-	const DISCORDBASEURI = "https://discord.com/api/v8"
-	u, _ := url.Parse(DISCORDBASEURI)
-	u.Path = path.Join(u.Path, endpoint)
-
-	// Error conditions for http.NewRequest are
-	// - Invalid method (taken care of using http.MethodGet)
-	// - Nil context (using background context)
-	// - Invalid Url (assume url is valid)
-	// - Invalid Body (body is nil here)
-	// [see here](https://go.googlesource.com/go/+/go1.16.2/src/net/http/request.go#853)
-	req, _ := http.NewRequest(method, u.String(), nil)
-	req.Header.Add("Authorization", auth)
-
-	resp, err := httpClient.Do(req)
+func writeResults(w http.ResponseWriter, results []quotestore.SearchResult, limit int, err error) (int, error) {
 	if err != nil {
-		return nil, err
+		return http.StatusInternalServerError, fmt.Errorf("search quotes failed/%s", err)
 	}
 
-	if resp.StatusCode >= 400 {
-		return nil, fmt.Errorf("resp %s", resp.Status)
+	if len(results) > limit {
+		results = results[:limit]
 	}
 
-	out, _ := ioutil.ReadAll(resp.Body)
-	return out, nil
+	resultsJSON, err := json.Marshal(results)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("json marshal failed/%s", err)
+	}
+
+	fmt.Fprint(w, string(resultsJSON))
+	return http.StatusOK, nil
 }
 
-func hasGuildAccess(guildID, auth string) bool {
-	respBody, err := discordRequest(http.MethodGet, "/users/@me/guilds", auth)
+func writePage(w http.ResponseWriter, page quotestore.QuotePage, err error) (int, error) {
+	if errors.Is(err, quotestore.ErrInvalidCursor) {
+		return http.StatusBadRequest, fmt.Errorf("invalid request, %s", err)
+	} else if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("get quotes failed/%s", err)
+	}
+
+	pageJSON, err := json.Marshal(page)
 	if err != nil {
-		log.Printf("ERROR: discord request failed %s", err)
-		return false
+		return http.StatusInternalServerError, fmt.Errorf("json marshal failed/%s", err)
 	}
-	var guilds []discord.Guild
-	err = json.Unmarshal(respBody, &guilds)
+
+	fmt.Fprint(w, string(pageJSON))
+	return http.StatusOK, nil
+}
+
+/**
+ * Handler for the retriveing information about stored quotes
+ * @url_param guild_id string
+ * @url_param user_id string
+ *
+ * @query_params search string a query to search the users quotes with
+ *	see quotestore/query.go for the syntax
+ * @query_params fuzzy bool tolerate typos in the searched words if true
+ * @query_params limit uint limit the amount of results that can be returned, at least 1 default 100
+ * @query_params random bool only return random quotes if true
+ * @query_params count uint amount of distinct random quotes to return, at least 1 default 1, at most limit
+ * @query_params cursor string next_cursor from the previous page
+ * @query_params sort string asc or desc order by date default asc
+ *
+ * search results are ordered by relevance and include a score field
+ * when not searching or picking random quotes the response is a page of the form
+ *	{"quotes": [...], "next_cursor": "..."} next_cursor is omitted on the last page
+ */
+func getQuotesForUser(ctx context.Context, w http.ResponseWriter, r *http.Request, _ httprouter.Params) (int, error) {
+	ids := requestIDs(r)
+	guildID, userID := ids.guild, ids.user
+	query, err := parseQuotesQuery(r)
 	if err != nil {
-		log.Printf("ERROR: discord request parse failed %s", err)
-		return false
+		return http.StatusBadRequest, fmt.Errorf("invalid request, %s", err)
+	}
+
+	if len(query.search.Terms) > 0 {
+		results, err := stenoStore.Search(ctx, guildID, userID, query.search)
+		if err == nil && len(results) == 0 {
+			return http.StatusNotFound, httptools.NotFound("no quotes for user/%s", userID)
+		}
+		return writeResults(w, results, query.limit, err)
 	}
 
-	for _, guild := range guilds {
-		if guild.ID == guildID {
-			return true
+	var quotes []quotestore.Quote
+	if query.random {
+		quotes, err = stenoStore.GetRandom(ctx, guildID, userID, query.count)
+	} else {
+		page, err := stenoStore.List(ctx, guildID, userID, query.page)
+		if err == nil && len(page.Quotes) == 0 && query.page.Cursor == "" {
+			return http.StatusNotFound, httptools.NotFound("no quotes for user/%s", userID)
 		}
+		return writePage(w, page, err)
 	}
-	return false
+
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("get quotes failed/%s", err)
+	}
+
+	if len(quotes) <= 0 {
+		return http.StatusNotFound, httptools.NotFound("no quotes for user/%s", userID)
+	}
+
+	return writeQuotes(w, quotes, query.limit)
+}
+
+/**
+ * Handler for the retriveing quotes from every user in a guild
+ * @url_param guild_id string
+ *
+ * @query_params search string a query to search the guilds quotes with
+ *	see quotestore/query.go for the syntax
+ * @query_params fuzzy bool tolerate typos in the searched words if true
+ * @query_params limit uint limit the amount of results that can be returned, at least 1 default 100
+ * @query_params random bool only return random quotes if true
+ * @query_params count uint amount of distinct random quotes to return, at least 1 default 1, at most limit
+ * @query_params cursor string next_cursor from the previous page
+ * @query_params sort string asc or desc order by date default asc
+ *
+ * search results are ordered by relevance and include a score field
+ * when not searching or picking random quotes the response is a page of the form
+ *	{"quotes": [...], "next_cursor": "..."} next_cursor is omitted on the last page
+ */
+func getQuotesForGuild(ctx context.Context, w http.ResponseWriter, r *http.Request, _ httprouter.Params) (int, error) {
+	guildID := requestIDs(r).guild
+	query, err := parseQuotesQuery(r)
+	if err != nil {
+		return http.StatusBadRequest, fmt.Errorf("invalid request, %s", err)
+	}
+
+	if len(query.search.Terms) > 0 {
+		results, err := stenoStore.GuildSearch(ctx, guildID, query.search)
+		if err == nil && len(results) == 0 {
+			return http.StatusNotFound, httptools.NotFound("no quotes for guild/%s", guildID)
+		}
+		return writeResults(w, results, query.limit, err)
+	}
+
+	var quotes []quotestore.Quote
+	if query.random {
+		quotes, err = stenoStore.GuildGetRandom(ctx, guildID, query.count)
+	} else {
+		page, err := stenoStore.GuildList(ctx, guildID, query.page)
+		if err == nil && len(page.Quotes) == 0 && query.page.Cursor == "" {
+			return http.StatusNotFound, httptools.NotFound("no quotes for guild/%s", guildID)
+		}
+		return writePage(w, page, err)
+	}
+
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("get quotes failed/%s", err)
+	}
+
+	if len(quotes) <= 0 {
+		return http.StatusNotFound, httptools.NotFound("no quotes for guild/%s", guildID)
+	}
+
+	return writeQuotes(w, quotes, query.limit)
+}
+
+// who auth belongs to from /users/@me and the guilds it has access to from
+// /users/@me/guilds, both are cached per token
+func tokenAccess(ctx context.Context, auth string) (guildcache.Access, error) {
+	return guildAccess.Access(ctx, auth, func(ctx context.Context) (guildcache.Access, error) {
+		user, err := discordClient.CurrentUser(ctx, auth)
+		if err != nil {
+			return guildcache.Access{}, err
+		}
+		guilds, err := discordClient.CurrentUserGuilds(ctx, auth)
+		if err != nil {
+			return guildcache.Access{}, err
+		}
+		return guildcache.Access{User: user, Guilds: guilds}, nil
+	})
+}
+
+// the problem for a failed discord request, rate limits that couldn't be
+// waited out in time are worth retrying so they aren't reported as failures
+func discordProblem(err error) (int, error) {
+	var apiErr *discord.APIError
+	if errors.As(err, &apiErr) && apiErr.Status == http.StatusTooManyRequests {
+		return http.StatusServiceUnavailable, httptools.Unavailable(
+			"discord is rate limiting requests, retry after %.1fs", apiErr.RetryAfter)
+	}
+	return http.StatusBadGateway, httptools.Upstream("discord", err)
+}
+
+// resolve the member a bot is acting for and their permissions from their
+// roles, ok is false if they aren't a member of the guild
+func actingMember(ctx context.Context, guildID, userID discord.Snowflake, auth string) (discord.User, discord.Permissions, bool, error) {
+	guild, ok := guildMembers.Guild(guildID)
+	if !ok {
+		var err error
+		guild, err = discordClient.Guild(ctx, auth, guildID)
+		if err != nil {
+			return discord.User{}, 0, false, err
+		}
+		guildMembers.SetGuild(guildID, guild)
+	}
+
+	member, err := guildMember(ctx, guildID, userID, auth)
+	if err != nil || member == nil {
+		return discord.User{}, 0, false, err
+	}
+	return member.User, discord.ComputePermissions(guild, *member), true, nil
+}
+
+// userID's member of guildID through the member cache, nil if they aren't a member
+func guildMember(ctx context.Context, guildID, userID discord.Snowflake, auth string) (*discord.GuildMember, error) {
+	if member, ok := guildMembers.Member(guildID, userID); ok {
+		return member, nil
+	}
+
+	member, err := discordClient.GuildMember(ctx, auth, guildID, userID)
+	var apiErr *discord.APIError
+	if errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound {
+		guildMembers.SetMember(guildID, userID, nil)
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	guildMembers.SetMember(guildID, userID, &member)
+	return &member, nil
+}
+
+// the discord user making a request, set by authenticate
+type identity struct {
+	User        discord.User
+	TokenType   string              // "Bot" or "Bearer"
+	Auth        string              // the Authorization header the user was resolved from
+	Permissions discord.Permissions // guild wide permissions in the requested guild
+}
+
+type identityKey struct{}
+
+func requestIdentity(r *http.Request) (identity, bool) {
+	id, ok := httptools.Value(r, identityKey{}).(identity)
+	return id, ok
+}
+
+// the discord user that is making the request with an OAuth2 Bearer token,
+// bot tokens act on behalf of other users so don't count
+func requestUser(r *http.Request) (discord.User, bool) {
+	id, ok := requestIdentity(r)
+	if !ok || id.TokenType != "Bearer" {
+		return discord.User{}, false
+	}
+	return id.User, true
+}
+
+// the route's ids as parsed by validateRouteIDs, zero when the route doesn't have the param
+type routeIDs struct {
+	guild discord.Snowflake
+	user  discord.Snowflake
+}
+
+type routeIDsKey struct{}
+
+func requestIDs(r *http.Request) routeIDs {
+	ids, _ := httptools.Value(r, routeIDsKey{}).(routeIDs)
+	return ids
+}
+
+/** Handler for rejecting malformed ids before they are sent to discord or
+ *  used to build store keys, the parsed ids are made available to later
+ *  handlers with requestIDs
+ *
+ *  @url_param guild_id string
+ *  @url_param user_id string optional
+ *  @url_param quote_id string optional
+ */
+func validateRouteIDs(_ context.Context, _ http.ResponseWriter, r *http.Request, ps httprouter.Params) (int, error) {
+	var ids routeIDs
+	for _, p := range ps {
+		var err error
+		switch p.Key {
+		case "guild_id":
+			ids.guild, err = discord.ParseSnowflake(p.Value)
+		case "user_id":
+			ids.user, err = discord.ParseSnowflake(p.Value)
+		case "quote_id":
+			if !quotestore.IsQuoteID(p.Value) {
+				err = fmt.Errorf("invalid quote id %q, expected 1 to 64 letters, digits, - or _", p.Value)
+			}
+		}
+		if err != nil {
+			return http.StatusBadRequest, httptools.ProblemNew(http.StatusBadRequest, "invalid_id", "%s %s", p.Key, err)
+		}
+	}
+
+	httptools.SetValue(r, routeIDsKey{}, ids)
+	return http.StatusOK, nil
 }
 
 /** Handler for authenticating a particular request against the discord api
+ *  the user the token belongs to is resolved with /users/@me and made available
+ *  to later handlers with requestIdentity, the user and their guilds are
+ *  cached per token for STENO_GUILD_CACHE_TTL
  *
  *  @url_param guild_id string guildID that is being queried
  *
  *  @header Authorization discord Authorization header
 		of the form "Bearer {token}" or "Bot {token}"
+		Bearer tokens need the identify and guilds OAuth2 scopes
+ *  @header X-Steno-Acting-User optional id of the guild member a Bot token is acting for,
+		only honoured for steno's own bot from STENO_DISCORD_APP_ID
 */
-func authenticate(_ http.ResponseWriter, r *http.Request, ps httprouter.Params) (int, error) {
-	guildID := ps.ByName("guild_id")
+func authenticate(ctx context.Context, _ http.ResponseWriter, r *http.Request, _ httprouter.Params) (int, error) {
+	guildID := requestIDs(r).guild
 
 	authorization := r.Header["Authorization"]
 	if len(authorization) == 0 {
 		return http.StatusBadRequest, errors.New("invalid request, No Authorization")
 	}
 	auth := authorization[0]
-	tokenType := strings.Split(auth, " ")[0] // Bearer ...
-	// token := strings.Split(auth, " ")[1]   // ... {token}
+	parts := strings.SplitN(auth, " ", 2) // {Bearer|Bot} {token}
+	if len(parts) != 2 || parts[1] == "" {
+		return http.StatusBadRequest, errors.New("invalid request, Bad token")
+	}
 
-	if tokenType != "Bot" {
+	tokenType := parts[0]
+	if tokenType != "Bot" && tokenType != "Bearer" {
 		return http.StatusBadRequest, errors.New("invalid request, Bad token")
 	}
 
-	// respBody, err := discordRequest(http.MethodGet, "/oauth2/applications/@me", auth)
-	// if err != nil {
-	// 	return http.StatusInternalServerError, fmt.Errorf("discord request failed: %s", err)
-	// }
-	// var discordApp discord.Application
-	// err = json.Unmarshal(respBody, &discordApp)
-	// if err != nil {
-	// 	return http.StatusInternalServerError, fmt.Errorf("disord request parsing failed: %s", err)
-	// }
+	access, err := tokenAccess(ctx, auth)
+	var apiErr *discord.APIError
+	if errors.As(err, &apiErr) && apiErr.Status == http.StatusUnauthorized {
+		return http.StatusUnauthorized, fmt.Errorf("discord rejected token/%s", err)
+	} else if err != nil {
+		return discordProblem(err)
+	}
+	user := access.User
+
+	guild, ok := access.Guild(guildID)
+	if !ok {
+		return http.StatusForbidden, httptools.Forbidden("discord token does not have access to that guild")
+	}
+	perms, err := discord.GuildPermissions(guild)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("discord permissions parsing failed/%s", err)
+	}
 
-	if !hasGuildAccess(guildID, auth) {
-		return http.StatusForbidden,
-			errors.New("discord bearer token does not have access to that guild")
+	// steno's bot can name the member it is acting for, it is then authorized
+	// as that member instead of as the bot, any other bot in the guild could
+	// use it to act as the owner
+	if acting := r.Header.Get("X-Steno-Acting-User"); acting != "" {
+		if tokenType != "Bot" || stenoBotID == 0 || user.ID != stenoBotID.String() {
+			return http.StatusForbidden, httptools.Forbidden("only steno's bot can act for other users")
+		}
+		actingID, err := discord.ParseSnowflake(acting)
+		if err != nil {
+			return http.StatusBadRequest, httptools.ProblemNew(http.StatusBadRequest, "invalid_id", "X-Steno-Acting-User %s", err)
+		}
+
+		user, perms, ok, err = actingMember(ctx, guildID, actingID, auth)
+		if errors.As(err, &apiErr) && apiErr.Status < http.StatusInternalServerError &&
+			apiErr.Status != http.StatusTooManyRequests {
+			return http.StatusForbidden, httptools.Forbidden("acting user is not a member of that guild/%s", err)
+		} else if err != nil {
+			return discordProblem(err)
+		} else if !ok {
+			return http.StatusForbidden, httptools.Forbidden("acting user is not a member of that guild")
+		}
 	}
 
+	httptools.SetUser(r, user.ID)
+	httptools.SetValue(r, identityKey{}, identity{
+		User:        user,
+		TokenType:   tokenType,
+		Auth:        auth,
+		Permissions: perms,
+	})
 	return http.StatusOK, nil
 }
 
+// the Authorization header for a bot token, the "Bot " prefix is optional
+func botAuthorization(token string) string {
+	if strings.HasPrefix(token, "Bot ") {
+		return token
+	}
+	return "Bot " + token
+}
+
+// whether userID is a member of guildID, looked up with the bot token
+func isGuildMember(ctx context.Context, guildID, userID discord.Snowflake) (bool, error) {
+	member, err := guildMember(ctx, guildID, userID, botAuth)
+	return member != nil, err
+}
+
+// check the user ids of a quote, users maps json field names to ids and
+// empty ids are skipped since both fields are optional
+func validateQuoteUsers(ctx context.Context, guildID discord.Snowflake, users map[string]string) (int, error) {
+	if validateUsers == validateNone {
+		return http.StatusOK, nil
+	}
+
+	fieldErrs := make(httptools.FieldErrors)
+	for field, id := range users {
+		if id == "" {
+			continue
+		}
+		userID, err := discord.ParseSnowflake(id)
+		if err != nil {
+			fieldErrs[field] = "is not a discord user id"
+			continue
+		}
+		if validateUsers != validateMember {
+			continue
+		}
+
+		member, err := isGuildMember(ctx, guildID, userID)
+		if err != nil {
+			return discordProblem(err)
+		}
+		if !member {
+			fieldErrs[field] = "is not a member of the guild"
+		}
+	}
+
+	if len(fieldErrs) > 0 {
+		return http.StatusUnprocessableEntity, httptools.Validation(fieldErrs)
+	}
+	return http.StatusOK, nil
+}
+
+// check that the requesting user may change quote, they have to have said or
+// recorded it or be able to manage messages in the guild
+func authorizeQuoteChange(r *http.Request, quote quotestore.Quote, action string) (int, error) {
+	id, ok := requestIdentity(r)
+	if !ok {
+		return http.StatusForbidden, httptools.Forbidden("cannot %s quote without an authenticated user", action)
+	}
+
+	if id.User.ID != "" && (id.User.ID == quote.AuthorID || id.User.ID == quote.StenographerID) {
+		return http.StatusOK, nil
+	}
+	if id.Permissions.Has(discord.PermissionManageMessages) {
+		return http.StatusOK, nil
+	}
+
+	return http.StatusForbidden, httptools.Forbidden(
+		"only the quote's author or stenographer or members with "+
+			"MANAGE_MESSAGES or ADMINISTRATOR can %s quote/%s", action, quote.ID)
+}
+
+// the api's routes, stenoStore, guildAccess, discordClient and interactionKey have to be set first
+func newRouter() *httprouter.Router {
+	baseRoute := httptools.RouteNew().Log().Timeout(routeTimeout).
+		Gate(validateRouteIDs).Gate(authenticate)
+
+	router := httprouter.New()
+	baseRoute.Register(router, http.MethodGet, "/quotes/:guild_id", getQuotesForGuild)
+	baseRoute.Register(router, http.MethodGet, "/quotes/:guild_id/:user_id", getQuotesForUser)
+	baseRoute.Register(router, http.MethodPost, "/quotes/:guild_id/:user_id", addQuotes)
+	baseRoute.Register(router, http.MethodDelete, "/quotes/:guild_id/:user_id", removeQuotes)
+	baseRoute.Register(router, http.MethodGet, "/quotes/:guild_id/:user_id/:quote_id", getQuote)
+	baseRoute.Register(router, http.MethodDelete, "/quotes/:guild_id/:user_id/:quote_id", removeQuoteByID)
+	baseRoute.Register(router, http.MethodPatch, "/quotes/:guild_id/:user_id/:quote_id", editQuote)
+	baseRoute.Register(router, http.MethodGet, "/quotes/:guild_id/:user_id/:quote_id/revisions", getRevisions)
+
+	// scrapes and probes every few seconds would drown out the access log
+	httptools.RouteNew().Timeout(routeTimeout).Gate(authorizeMetrics).
+		Register(router, http.MethodGet, "/metrics", getMetrics)
+	httptools.RouteNew().Register(router, http.MethodGet, "/healthz", getHealth)
+	httptools.RouteNew().Timeout(routeTimeout).Register(router, http.MethodGet, "/readyz", getReadiness)
+
+	if interactionKey != nil {
+		httptools.RouteNew().Log().Timeout(interactionTimeout).Gate(verifyInteraction).
+			Register(router, http.MethodPost, "/interactions", handleInteraction)
+	}
+
+	return router
+}
+
 func main() {
+	// steno commands sync registers the slash commands instead of serving the api
+	if len(os.Args) > 1 && os.Args[1] == "commands" {
+		os.Exit(commandsMain(os.Args[2:]))
+	}
 
 	log.SetOutput(os.Stdout)
 
-	stenoStore = quotestore.Connect(os.Getenv("STENO_REDIS_ADDR"), "", 0)
-	// stenoStore.LoadSavedData("")
+	// STENO_STORE=memory runs without redis, quotes are lost on restart
+	switch os.Getenv("STENO_STORE") {
+	case "memory":
+		stenoStore = quotestore.MemoryStoreNew()
+	default:
+		redisStore := quotestore.Connect(os.Getenv("STENO_REDIS_ADDR"), "", 0)
+		// redisStore.LoadSavedData("")
+
+		// convert quotes saved in the old list layout and build the search index,
+		// a no-op once migrated
+		if _, err := redisStore.Migrate(context.Background()); err != nil {
+			log.Printf("ERROR: redis migration failed %s", err)
+		}
+		stenoStore = redisStore
+		readinessChecks = append(readinessChecks, dependencyCheck{
+			name: "redis", required: true, check: redisStore.Ping,
+		})
+	}
+	stenoStore = quotestore.MeteredStoreNew(stenoStore)
+	registerMetrics()
+
+	// STENO_METRICS_TOKEN has to be sent as a Bearer token to read /metrics
+	metricsToken = os.Getenv("STENO_METRICS_TOKEN")
+
+	// STENO_ROUTE_TIMEOUT how long a request can wait on redis and discord, 0 for no limit
+	if timeout := os.Getenv("STENO_ROUTE_TIMEOUT"); timeout != "" {
+		var err error
+		routeTimeout, err = time.ParseDuration(timeout)
+		if err != nil {
+			log.Fatalf("invalid STENO_ROUTE_TIMEOUT %s", err)
+		}
+	}
+
+	// STENO_GUILD_CACHE_TTL how long a token's user and guilds are cached, 0 disables the cache
+	// STENO_GUILD_CACHE=redis shares the cache between replicas through STENO_REDIS_ADDR
+	guildCacheTTL := time.Minute
+	if ttl := os.Getenv("STENO_GUILD_CACHE_TTL"); ttl != "" {
+		var err error
+		guildCacheTTL, err = time.ParseDuration(ttl)
+		if err != nil {
+			log.Fatalf("invalid STENO_GUILD_CACHE_TTL %s", err)
+		}
+	}
+	switch {
+	case guildCacheTTL <= 0:
+		guildAccess = guildcache.LoaderNew(nil)
+	case os.Getenv("STENO_GUILD_CACHE") == "redis":
+		cache := guildcache.Connect(os.Getenv("STENO_REDIS_ADDR"), "", 0, guildCacheTTL)
+		guildAccess = guildcache.LoaderNew(cache)
+		// a cache that's down only means asking discord every time
+		readinessChecks = append(readinessChecks, dependencyCheck{
+			name: "guild_cache", required: false, check: cache.Ping,
+		})
+	default:
+		guildAccess = guildcache.LoaderNew(guildcache.MemoryCacheNew(guildCacheTTL))
+	}
 
-	httpClient = &http.Client{}
-	baseRoute := httptools.RouteNew().Log().Gate(authenticate)
+	// STENO_VALIDATE_USERS=snowflake rejects quotes with malformed user ids,
+	// =member also requires them to be guild members, looked up with the bot
+	// token in STENO_DISCORD_TOKEN and cached for STENO_GUILD_CACHE_TTL
+	switch v := os.Getenv("STENO_VALIDATE_USERS"); v {
+	case "":
+	case "snowflake":
+		validateUsers = validateSnowflake
+	case "member":
+		validateUsers = validateMember
+		if os.Getenv("STENO_DISCORD_TOKEN") == "" {
+			log.Fatal("STENO_VALIDATE_USERS=member needs a bot token in STENO_DISCORD_TOKEN")
+		}
+		botAuth = botAuthorization(os.Getenv("STENO_DISCORD_TOKEN"))
+	default:
+		log.Fatalf("invalid STENO_VALIDATE_USERS %s, expected snowflake or member", v)
+	}
+	guildMembers = guildcache.MemberCacheNew(guildCacheTTL)
+
+	// STENO_DISCORD_APP_ID is steno's application, its bot is the only one
+	// X-Steno-Acting-User is honoured for, without it the header is refused
+	if appID := os.Getenv("STENO_DISCORD_APP_ID"); appID != "" {
+		var err error
+		stenoBotID, err = discord.ParseSnowflake(appID)
+		if err != nil {
+			log.Fatalf("invalid STENO_DISCORD_APP_ID %s", err)
+		}
+	}
 
-	router := httprouter.New()
-	router.GET("/quotes/:guild_id/:user_id", baseRoute.Clone().Finish(getQuotesForUser))
-	router.POST("/quotes/:guild_id/:user_id", baseRoute.Clone().Finish(addQuotes))
-	router.DELETE("/quotes/:guild_id/:user_id", baseRoute.Clone().Finish(removeQuotes))
+	discordClient = discord.ClientNew()
+	// STENO_DISCORD_API points at another discord api e.g. a discordtest server
+	if base := os.Getenv("STENO_DISCORD_API"); base != "" {
+		discordClient.BaseURL = base
+	}
+
+	// STENO_READY_DISCORD=optional reports whether discord can be reached on
+	// /readyz, =required also makes steno unready while it can't
+	switch v := os.Getenv("STENO_READY_DISCORD"); v {
+	case "":
+	case "optional", "required":
+		readinessChecks = append(readinessChecks, dependencyCheck{
+			name:     "discord",
+			required: v == "required",
+			check: func(ctx context.Context) error {
+				_, err := discordClient.Gateway(ctx)
+				return err
+			},
+		})
+	default:
+		log.Fatalf("invalid STENO_READY_DISCORD %s, expected optional or required", v)
+	}
+
+	// STENO_DISCORD_PUBLIC_KEY enables slash commands on /interactions
+	if key := os.Getenv("STENO_DISCORD_PUBLIC_KEY"); key != "" {
+		k, err := hex.DecodeString(key)
+		if err != nil || len(k) != ed25519.PublicKeySize {
+			log.Fatal("invalid STENO_DISCORD_PUBLIC_KEY, expected the hex public key from the developer portal")
+		}
+		interactionKey = ed25519.PublicKey(k)
+	}
 
-	log.Fatal(http.ListenAndServe(":8080", router))
+	log.Fatal(http.ListenAndServe(":8080", newRouter()))
 }
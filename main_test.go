@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"steno/discord"
+	"steno/discord/discordtest"
+	"steno/guildcache"
+	"steno/httptools"
+	"steno/quotestore"
+)
+
+// point the api at a fake discord serving the default fixtures with an
+// empty memory store
+func setup(t *testing.T) *discordtest.Server {
+	t.Helper()
+	srv := discordtest.ServerNew(discordtest.DefaultFixtures())
+	t.Cleanup(srv.Close)
+
+	discordClient = discord.ClientNew()
+	discordClient.BaseURL = srv.URL
+	guildAccess = guildcache.LoaderNew(guildcache.MemoryCacheNew(time.Minute))
+	guildMembers = guildcache.MemberCacheNew(time.Minute)
+	stenoStore = quotestore.MemoryStoreNew()
+	validateUsers = validateNone
+	botAuth = ""
+	stenoBotID = snowflake(discordtest.BotID)
+	httptools.AccessLog = io.Discard
+	return srv
+}
+
+type request struct {
+	method  string
+	path    string
+	auth    string
+	body    string
+	headers map[string]string
+}
+
+func (req request) do(t *testing.T) (int, httptools.Problem) {
+	t.Helper()
+	var body io.Reader
+	if req.body != "" {
+		body = strings.NewReader(req.body)
+	}
+	r := httptest.NewRequest(req.method, req.path, body)
+	if req.auth != "" {
+		r.Header.Set("Authorization", req.auth)
+	}
+	if req.body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range req.headers {
+		r.Header.Set(k, v)
+	}
+
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, r)
+
+	var p httptools.Problem
+	if strings.HasPrefix(w.Header().Get("Content-Type"), "application/problem+json") {
+		if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+			t.Fatalf("%s %s: invalid problem %s", req.method, req.path, w.Body)
+		}
+	}
+	return w.Code, p
+}
+
+// the fixture ids are all well formed
+func snowflake(id string) discord.Snowflake {
+	s, err := discord.ParseSnowflake(id)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func pushQuote(t *testing.T, userID string, q quotestore.Quote) {
+	t.Helper()
+	if err := stenoStore.Push(context.Background(), snowflake(discordtest.GuildID), snowflake(userID), q); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	srv := setup(t)
+	pushQuote(t, discordtest.MemberID, quotestore.Quote{ID: "q1", AuthorID: discordtest.MemberID, Str: "hello"})
+	guildPath := "/quotes/" + discordtest.GuildID
+
+	tests := []struct {
+		name   string
+		req    request
+		status int
+		code   string
+	}{
+		{"no authorization", request{path: guildPath}, 400, "bad_request"},
+		{"unknown token type", request{path: guildPath, auth: "Basic abc"}, 400, "bad_request"},
+		{"token discord rejects", request{path: guildPath, auth: "Bearer nope"}, 401, "unauthorized"},
+		{"user outside the guild", request{path: guildPath, auth: discordtest.OutsiderToken}, 403, "forbidden"},
+		{"guild member", request{path: guildPath, auth: discordtest.MemberToken}, 200, ""},
+		{"guild owner", request{path: guildPath, auth: discordtest.OwnerToken}, 200, ""},
+		{"bot", request{path: guildPath, auth: discordtest.BotToken}, 200, ""},
+		{"bot acting for a member", request{path: guildPath, auth: discordtest.BotToken,
+			headers: map[string]string{"X-Steno-Acting-User": discordtest.ModID}}, 200, ""},
+		{"bot acting for an outsider", request{path: guildPath, auth: discordtest.BotToken,
+			headers: map[string]string{"X-Steno-Acting-User": discordtest.OutsiderID}}, 403, "forbidden"},
+		{"another bot acting for the owner", request{path: guildPath, auth: discordtest.OtherBotToken,
+			headers: map[string]string{"X-Steno-Acting-User": discordtest.OwnerID}}, 403, "forbidden"},
+		{"user acting for the owner", request{path: guildPath, auth: discordtest.MemberToken,
+			headers: map[string]string{"X-Steno-Acting-User": discordtest.OwnerID}}, 403, "forbidden"},
+		{"malformed guild id", request{path: "/quotes/abc", auth: discordtest.MemberToken}, 400, "invalid_id"},
+	}
+	for _, tt := range tests {
+		tt.req.method = http.MethodGet
+		status, p := tt.req.do(t)
+		if status != tt.status || p.Code != tt.code {
+			t.Errorf("%s: got %d %q, want %d %q", tt.name, status, p.Code, tt.status, tt.code)
+		}
+	}
+
+	// discord going away is its failure, not the client's, the mod's
+	// token hasn't been cached yet
+	srv.Close()
+	status, p := request{method: http.MethodGet, path: guildPath, auth: discordtest.ModToken}.do(t)
+	if status != http.StatusBadGateway || p.Code != "upstream_error" {
+		t.Errorf("discord down: got %d %q, want 502 upstream_error", status, p.Code)
+	}
+}
+
+func TestTokenAccessCached(t *testing.T) {
+	srv := setup(t)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		access, err := tokenAccess(ctx, discordtest.ModToken)
+		if err != nil || access.User.ID != discordtest.ModID {
+			t.Fatalf("mod: got %v %v, want the mod", access.User, err)
+		}
+		guild, ok := access.Guild(snowflake(discordtest.GuildID))
+		if !ok {
+			t.Fatal("mod: got no access to the guild")
+		}
+		perms, err := discord.GuildPermissions(guild)
+		if err != nil || !perms.Has(discord.PermissionManageMessages) {
+			t.Errorf("mod: got permissions %s %v, want MANAGE_MESSAGES", perms, err)
+		}
+	}
+
+	access, err := tokenAccess(ctx, discordtest.OutsiderToken)
+	if _, ok := access.Guild(snowflake(discordtest.GuildID)); err != nil || ok {
+		t.Errorf("outsider: got %v %v, want no access", ok, err)
+	}
+
+	// one lookup of each token's user and guilds, the rest came from the cache
+	for _, route := range []string{"GET /users/@me", "GET /users/@me/guilds"} {
+		if n := srv.Requests(route); n != 2 {
+			t.Errorf("got %d requests for %s, want 2", n, route)
+		}
+	}
+
+	// so does the bot acting for a member
+	guildPath := "/quotes/" + discordtest.GuildID
+	for i := 0; i < 2; i++ {
+		status, p := request{method: http.MethodGet, path: guildPath, auth: discordtest.BotToken,
+			headers: map[string]string{"X-Steno-Acting-User": discordtest.ModID}}.do(t)
+		if status != http.StatusNotFound {
+			t.Errorf("bot acting for the mod: got %d %q, want 404", status, p.Code)
+		}
+	}
+	for _, route := range []string{
+		"GET /guilds/" + discordtest.GuildID,
+		"GET /guilds/" + discordtest.GuildID + "/members/" + discordtest.ModID,
+	} {
+		if n := srv.Requests(route); n != 1 {
+			t.Errorf("got %d requests for %s, want 1", n, route)
+		}
+	}
+}
+
+func TestQuoteChangePermissions(t *testing.T) {
+	setup(t)
+	quotePath := "/quotes/" + discordtest.GuildID + "/" + discordtest.MemberID + "/"
+	for _, id := range []string{"q1", "q2", "q3", "q4"} {
+		pushQuote(t, discordtest.MemberID, quotestore.Quote{ID: id, AuthorID: discordtest.MemberID, Str: "hello"})
+	}
+
+	tests := []struct {
+		name    string
+		req     request
+		status  int
+		code    string
+		deleted bool
+	}{
+		{"outsider", request{path: quotePath + "q1", auth: discordtest.OutsiderToken}, 403, "forbidden", false},
+		{"bot without an acting user", request{path: quotePath + "q1", auth: discordtest.BotToken}, 403, "forbidden", false},
+		{"author", request{path: quotePath + "q1", auth: discordtest.MemberToken}, 200, "", true},
+		{"owner", request{path: quotePath + "q2", auth: discordtest.OwnerToken}, 200, "", true},
+		{"mod", request{path: quotePath + "q3", auth: discordtest.ModToken}, 200, "", true},
+		// the mod's MANAGE_MESSAGES comes from their role in the full guild
+		{"bot acting for the mod", request{path: quotePath + "q4", auth: discordtest.BotToken,
+			headers: map[string]string{"X-Steno-Acting-User": discordtest.ModID}}, 200, "", true},
+	}
+	for _, tt := range tests {
+		tt.req.method = http.MethodDelete
+		status, p := tt.req.do(t)
+		if status != tt.status || p.Code != tt.code {
+			t.Errorf("%s: got %d %q, want %d %q", tt.name, status, p.Code, tt.status, tt.code)
+		}
+
+		id := tt.req.path[strings.LastIndex(tt.req.path, "/")+1:]
+		_, err := stenoStore.GetByID(context.Background(), snowflake(discordtest.GuildID), snowflake(discordtest.MemberID), id)
+		if deleted := err != nil; deleted != tt.deleted {
+			t.Errorf("%s: quote deleted %v, want %v", tt.name, deleted, tt.deleted)
+		}
+	}
+}
+
+func TestValidateGuildMembers(t *testing.T) {
+	srv := setup(t)
+	validateUsers = validateMember
+	botAuth = discordtest.BotToken
+	usersPath := "/quotes/" + discordtest.GuildID + "/"
+
+	tests := []struct {
+		name   string
+		author string
+		status int
+		field  string
+	}{
+		{"member", discordtest.MemberID, 200, ""},
+		{"member again", discordtest.MemberID, 200, ""},
+		{"outsider", discordtest.OutsiderID, 422, "author_id"},
+		{"outsider again", discordtest.OutsiderID, 422, "author_id"},
+		{"not a snowflake", "bob", 422, "author_id"},
+	}
+	for i, tt := range tests {
+		status, p := request{
+			method: http.MethodPost,
+			path:   usersPath + discordtest.MemberID,
+			auth:   discordtest.OwnerToken,
+			body:   `{"id": "q` + string(rune('a'+i)) + `", "author_id": "` + tt.author + `", "str": "hello"}`,
+		}.do(t)
+		if status != tt.status || (tt.field != "" && p.Fields[tt.field] == "") {
+			t.Errorf("%s: got %d %v, want %d with %s invalid", tt.name, status, p.Fields, tt.status, tt.field)
+		}
+	}
+
+	// members and non members are both cached, malformed ids never reach discord
+	for _, id := range []string{discordtest.MemberID, discordtest.OutsiderID} {
+		route := "GET /guilds/" + discordtest.GuildID + "/members/" + id
+		if n := srv.Requests(route); n != 1 {
+			t.Errorf("got %d requests for %s, want 1", n, route)
+		}
+	}
+}
+
+func TestValidateRouteIDs(t *testing.T) {
+	setup(t)
+	pushQuote(t, discordtest.MemberID, quotestore.Quote{ID: "q1", AuthorID: discordtest.MemberID, Str: "hello"})
+	userPath := "/quotes/" + discordtest.GuildID + "/" + discordtest.MemberID
+
+	tests := []struct {
+		name   string
+		req    request
+		status int
+		code   string
+	}{
+		{"well formed ids", request{method: http.MethodGet, path: userPath + "/q1"}, 200, ""},
+		{"malformed guild id", request{method: http.MethodGet, path: "/quotes/1234/" + discordtest.MemberID + "/q1"}, 400, "invalid_id"},
+		{"malformed user id", request{method: http.MethodGet, path: "/quotes/" + discordtest.GuildID + "/me/q1"}, 400, "invalid_id"},
+		{"quote id with a key separator", request{method: http.MethodGet, path: userPath + "/q1:revisions"}, 400, "invalid_id"},
+		{"quote id with dots", request{method: http.MethodDelete, path: userPath + "/..q1"}, 400, "invalid_id"},
+		{"quote id too long", request{method: http.MethodGet, path: userPath + "/" + strings.Repeat("q", 65)}, 400, "invalid_id"},
+		{"malformed acting user", request{method: http.MethodGet, path: userPath + "/q1", auth: discordtest.BotToken,
+			headers: map[string]string{"X-Steno-Acting-User": "someone"}}, 400, "invalid_id"},
+		{"body quote id with a key separator", request{method: http.MethodPost, path: userPath,
+			body: `{"id": "q2:revisions", "str": "hello"}`}, 422, "validation_failed"},
+		{"body quote id", request{method: http.MethodPost, path: userPath,
+			body: `{"id": "q2", "str": "hello"}`}, 200, ""},
+	}
+	for _, tt := range tests {
+		if tt.req.auth == "" {
+			tt.req.auth = discordtest.MemberToken
+		}
+		status, p := tt.req.do(t)
+		if status != tt.status || p.Code != tt.code {
+			t.Errorf("%s: got %d %q, want %d %q", tt.name, status, p.Code, tt.status, tt.code)
+		}
+	}
+}
+
+func TestParseQuotesQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		limit int
+		count int
+		err   bool
+	}{
+		{query: "", limit: 100, count: 1},
+		{query: "random=true&count=5", limit: 100, count: 5},
+		{query: "count=1000000", limit: 100, count: 100},
+		{query: "count=10&limit=3", limit: 3, count: 3},
+		{query: "count=0", err: true},
+		{query: "count=-1", err: true},
+		{query: "count=many", err: true},
+		{query: "limit=0", err: true},
+		{query: "limit=-5", err: true},
+		{query: "limit=all", err: true},
+		{query: "sort=sideways", err: true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/quotes/1?"+tt.query, nil)
+		q, err := parseQuotesQuery(r)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%q: got no error", tt.query)
+			}
+			continue
+		}
+		if err != nil || q.limit != tt.limit || q.count != tt.count {
+			t.Errorf("%q: got limit %d count %d %v, want limit %d count %d",
+				tt.query, q.limit, q.count, err, tt.limit, tt.count)
+		}
+	}
+}
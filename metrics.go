@@ -0,0 +1,67 @@
+package main
+
+// /metrics in the prometheus text format, see the metrics package and the
+// steno_* metrics registered by httptools, quotestore, discord and guildcache
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+
+	"steno/metrics"
+)
+
+// bearer token scrapers have to send, empty leaves /metrics open
+var metricsToken string
+
+// register the metrics only main knows how to collect, called once stenoStore is set
+func registerMetrics() {
+	metrics.Default.GaugeFunc("steno_guild_quotes",
+		"Quotes stored per guild.", guildQuoteSamples, "guild_id")
+}
+
+func guildQuoteSamples(ctx context.Context) ([]metrics.Sample, error) {
+	counts, err := stenoStore.GuildCounts(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	samples := make([]metrics.Sample, 0, len(counts))
+	for guildID, n := range counts {
+		samples = append(samples, metrics.Sample{Labels: []string{guildID}, Value: float64(n)})
+	}
+	return samples, nil
+}
+
+/** Handler for checking the scraper's token when STENO_METRICS_TOKEN is set
+ *
+ *  @header Authorization of the form "Bearer {token}"
+ */
+func authorizeMetrics(_ context.Context, _ http.ResponseWriter, r *http.Request, _ httprouter.Params) (int, error) {
+	if metricsToken == "" {
+		return http.StatusOK, nil
+	}
+
+	want := []byte("Bearer " + metricsToken)
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+		return http.StatusUnauthorized, errors.New("invalid request, bad metrics token")
+	}
+	return http.StatusOK, nil
+}
+
+/**
+ * Handler for prometheus scrapes
+ */
+func getMetrics(ctx context.Context, w http.ResponseWriter, _ *http.Request, _ httprouter.Params) (int, error) {
+	w.Header().Set("Content-Type", metrics.ContentType)
+	err := metrics.Default.Write(ctx, w)
+	if err != nil {
+		// the body was already started, there is no answering with a problem
+		log.Printf("ERROR: writing metrics failed %s", err)
+	}
+	return http.StatusOK, nil
+}
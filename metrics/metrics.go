@@ -0,0 +1,337 @@
+// Package metrics keeps counters and histograms and writes them in the
+// prometheus text exposition format, it only does what steno needs so the
+// api doesn't pull in the prometheus client
+package metrics
+
+// metrics are registered once at startup and updated with label values in
+// the order the labels were registered in
+//
+//	var requests = metrics.Default.Counter("steno_requests_total", "Requests handled.", "route")
+//	requests.Inc("/quotes/:guild_id")
+//
+// which is written as
+//
+//	# HELP steno_requests_total Requests handled.
+//	# TYPE steno_requests_total counter
+//	steno_requests_total{route="/quotes/:guild_id"} 1
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ContentType is the content type of the text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are the upper bounds in seconds of latency histograms,
+// from 5ms to 10s
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type metric interface {
+	metricName() string
+	write(ctx context.Context, w *bufio.Writer)
+}
+
+// Registry is a set of metrics written together, it is safe for concurrent use
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+// Default is the registry steno's packages register their metrics with
+var Default = RegistryNew()
+
+func RegistryNew() *Registry {
+	return &Registry{}
+}
+
+// names have to be unique, registering one twice is a bug so it panics
+func (reg *Registry) register(m metric) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	for _, other := range reg.metrics {
+		if other.metricName() == m.metricName() {
+			panic(fmt.Sprintf("metrics: %s registered twice", m.metricName()))
+		}
+	}
+	reg.metrics = append(reg.metrics, m)
+}
+
+// Write writes every metric in the exposition format sorted by name, ctx
+// is passed to the functions of GaugeFuncs
+func (reg *Registry) Write(ctx context.Context, w io.Writer) error {
+	reg.mu.Lock()
+	metrics := make([]metric, len(reg.metrics))
+	copy(metrics, reg.metrics)
+	reg.mu.Unlock()
+
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].metricName() < metrics[j].metricName()
+	})
+
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(ctx, bw)
+	}
+	return bw.Flush()
+}
+
+// the name, help and label names shared by every metric type
+type series struct {
+	name   string
+	help   string
+	labels []string
+}
+
+func (s series) metricName() string {
+	return s.name
+}
+
+// map key of a set of label values, \xff can't appear in valid utf-8
+func labelKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+func (s series) check(values []string) {
+	if len(values) != len(s.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", s.name, len(s.labels), len(values)))
+	}
+}
+
+func (s series) header(w *bufio.Writer, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", s.name, escapeHelp(s.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", s.name, kind)
+}
+
+// write one sample line, extra is an additional label such as a bucket's le
+func (s series) sample(w *bufio.Writer, suffix string, values []string, extra string, v float64) {
+	w.WriteString(s.name)
+	w.WriteString(suffix)
+	if len(values) > 0 || extra != "" {
+		w.WriteByte('{')
+		for i, label := range s.labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", label, escapeLabel(values[i]))
+		}
+		if extra != "" {
+			if len(values) > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(extra)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// keys of m in order so the output is stable between scrapes
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// CounterVec is a counter per set of label values
+type CounterVec struct {
+	series
+
+	mu          sync.Mutex
+	values      map[string]float64
+	labelValues map[string][]string
+}
+
+// Counter registers a counter, names of counters should end in _total
+func (reg *Registry) Counter(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		series:      series{name: name, help: help, labels: labels},
+		values:      make(map[string]float64),
+		labelValues: make(map[string][]string),
+	}
+	reg.register(c)
+	return c
+}
+
+// Add adds v, which must not be negative, to the counter for labelValues
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	c.check(labelValues)
+	key := labelKey(labelValues)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.labelValues[key]; !ok {
+		c.labelValues[key] = append([]string(nil), labelValues...)
+	}
+	c.values[key] += v
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) write(_ context.Context, w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.header(w, "counter")
+	for _, key := range sortedKeys(c.labelValues) {
+		c.sample(w, "", c.labelValues[key], "", c.values[key])
+	}
+}
+
+type histogramValue struct {
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// HistogramVec is a histogram per set of label values
+type HistogramVec struct {
+	series
+	buckets []float64
+
+	mu          sync.Mutex
+	values      map[string]*histogramValue
+	labelValues map[string][]string
+}
+
+// Histogram registers a histogram with buckets as the upper bounds, nil
+// buckets uses DefaultBuckets
+func (reg *Registry) Histogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+
+	h := &HistogramVec{
+		series:      series{name: name, help: help, labels: labels},
+		buckets:     sorted,
+		values:      make(map[string]*histogramValue),
+		labelValues: make(map[string][]string),
+	}
+	reg.register(h)
+	return h
+}
+
+// Observe records v in the histogram for labelValues
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	h.check(labelValues)
+	key := labelKey(labelValues)
+	i := sort.SearchFloat64s(h.buckets, v) // first bucket with v <= bound
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hv, ok := h.values[key]
+	if !ok {
+		hv = &histogramValue{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = hv
+		h.labelValues[key] = append([]string(nil), labelValues...)
+	}
+	if i < len(hv.counts) {
+		hv.counts[i]++
+	}
+	hv.count++
+	hv.sum += v
+}
+
+// Since observes the seconds since start, for timing with defer
+func (h *HistogramVec) Since(start time.Time, labelValues ...string) {
+	h.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+func (h *HistogramVec) write(_ context.Context, w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.header(w, "histogram")
+	for _, key := range sortedKeys(h.labelValues) {
+		values, hv := h.labelValues[key], h.values[key]
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += hv.counts[i]
+			h.sample(w, "_bucket", values, fmt.Sprintf("le=\"%s\"", formatFloat(bound)), float64(cumulative))
+		}
+		h.sample(w, "_bucket", values, `le="+Inf"`, float64(hv.count))
+		h.sample(w, "_sum", values, "", hv.sum)
+		h.sample(w, "_count", values, "", float64(hv.count))
+	}
+}
+
+// Sample is one value of a GaugeFunc, Labels are in the order the gauge's
+// labels were registered in
+type Sample struct {
+	Labels []string
+	Value  float64
+}
+
+type gaugeFunc struct {
+	series
+	fn func(ctx context.Context) ([]Sample, error)
+}
+
+// GaugeFunc registers a gauge whose samples are read from fn on every
+// scrape, for values that are kept elsewhere like the number of quotes.
+// when fn fails the error is logged and the gauge is written without samples
+func (reg *Registry) GaugeFunc(name, help string, fn func(ctx context.Context) ([]Sample, error), labels ...string) {
+	reg.register(&gaugeFunc{
+		series: series{name: name, help: help, labels: labels},
+		fn:     fn,
+	})
+}
+
+func (g *gaugeFunc) write(ctx context.Context, w *bufio.Writer) {
+	g.header(w, "gauge")
+	samples, err := g.fn(ctx)
+	if err != nil {
+		log.Printf("ERROR: metrics collecting %s failed %s", g.name, err)
+		return
+	}
+
+	sort.Slice(samples, func(i, j int) bool {
+		return labelKey(samples[i].Labels) < labelKey(samples[j].Labels)
+	})
+	for _, s := range samples {
+		g.check(s.Labels)
+		g.sample(w, "", s.Labels, "", s.Value)
+	}
+}
@@ -0,0 +1,81 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	reg := RegistryNew()
+	requests := reg.Counter("test_requests_total", "Requests handled.\nBy route.", "route", "status")
+	requests.Inc("/quotes/:guild_id", "200")
+	requests.Add(2, "/quotes/:guild_id", "200")
+	requests.Inc(`/say/"hi"\n`, "500")
+
+	latency := reg.Histogram("test_latency_seconds", "Time taken.", []float64{1, 0.1, 0.5}, "op")
+	latency.Observe(0.05, "get")
+	latency.Observe(0.5, "get")
+	latency.Observe(3, "get")
+	latency.Observe(0.2, "push")
+
+	reg.GaugeFunc("test_quotes", "Quotes stored.", func(ctx context.Context) ([]Sample, error) {
+		return []Sample{
+			{Labels: []string{"200000000000000002"}, Value: 1.5},
+			{Labels: []string{"100000000000000001"}, Value: 3},
+		}, nil
+	}, "guild")
+	reg.GaugeFunc("test_broken", "Always fails.", func(ctx context.Context) ([]Sample, error) {
+		return nil, errors.New("redis down")
+	})
+	reg.Counter("test_unlabeled_total", "No labels.").Inc()
+
+	var out bytes.Buffer
+	if err := reg.Write(context.Background(), &out); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# HELP test_broken Always fails.
+# TYPE test_broken gauge
+# HELP test_latency_seconds Time taken.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{op="get",le="0.1"} 1
+test_latency_seconds_bucket{op="get",le="0.5"} 2
+test_latency_seconds_bucket{op="get",le="1"} 2
+test_latency_seconds_bucket{op="get",le="+Inf"} 3
+test_latency_seconds_sum{op="get"} 3.55
+test_latency_seconds_count{op="get"} 3
+test_latency_seconds_bucket{op="push",le="0.1"} 0
+test_latency_seconds_bucket{op="push",le="0.5"} 1
+test_latency_seconds_bucket{op="push",le="1"} 1
+test_latency_seconds_bucket{op="push",le="+Inf"} 1
+test_latency_seconds_sum{op="push"} 0.2
+test_latency_seconds_count{op="push"} 1
+# HELP test_quotes Quotes stored.
+# TYPE test_quotes gauge
+test_quotes{guild="100000000000000001"} 3
+test_quotes{guild="200000000000000002"} 1.5
+# HELP test_requests_total Requests handled.\nBy route.
+# TYPE test_requests_total counter
+test_requests_total{route="/quotes/:guild_id",status="200"} 3
+test_requests_total{route="/say/\"hi\"\\n",status="500"} 1
+# HELP test_unlabeled_total No labels.
+# TYPE test_unlabeled_total counter
+test_unlabeled_total 1
+`
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	reg := RegistryNew()
+	reg.Counter("test_total", "Once.")
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a name twice didn't panic")
+		}
+	}()
+	reg.Histogram("test_total", "Twice.", nil)
+}
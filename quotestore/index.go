@@ -0,0 +1,290 @@
+package quotestore
+
+// full text index shared by the store backends
+// quote text is split into terms, each term maps to the ids of the quotes
+// containing it along with how many times it appears in that quote
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// SearchResult is a quote matched by a search, higher scores are more relevant
+type SearchResult struct {
+	Quote
+	Score float64 `json:"score"`
+}
+
+// fold case and strip accents so "Café" and "cafe" index the same
+func normalize(s string) string {
+	var b strings.Builder
+	for _, r := range norm.NFKD.String(s) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return norm.NFC.String(b.String())
+}
+
+// strip common english suffixes, not a full porter stemmer but enough
+// for "running", "runs" and "run" to land on the same term
+func stem(term string) string {
+	type rule struct{ suffix, replace string }
+	rules := []rule{
+		{"sses", "ss"}, {"ies", "y"}, {"ing", ""}, {"edly", ""},
+		{"ed", ""}, {"ly", ""}, {"es", ""}, {"s", ""},
+	}
+
+	runes := []rune(term)
+	for _, r := range rules {
+		if !strings.HasSuffix(term, r.suffix) {
+			continue
+		}
+		if r.suffix == "s" && strings.HasSuffix(term, "ss") {
+			return term
+		}
+
+		stemmed := strings.TrimSuffix(term, r.suffix) + r.replace
+		// boxes -> box but quotes -> quote
+		if r.suffix == "es" && !hasAnySuffix(stemmed, "x", "z", "ch", "sh") {
+			continue
+		}
+		// keep short words intact, "is" or "bed" are not plurals or past tense
+		if len([]rune(stemmed)) < 3 || len(runes) <= 3 {
+			return term
+		}
+
+		// running -> runn -> run
+		sr := []rune(stemmed)
+		if r.suffix == "ing" || r.suffix == "ed" {
+			n := len(sr)
+			if n >= 2 && sr[n-1] == sr[n-2] && !strings.ContainsRune("lsz", sr[n-1]) {
+				stemmed = string(sr[:n-1])
+			}
+		}
+		return stemmed
+	}
+	return term
+}
+
+func hasAnySuffix(s string, suffixes ...string) bool {
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(s, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Tokenize splits s into normalized and stemmed terms in order of appearance
+func Tokenize(s string) []string {
+	fields := strings.FieldsFunc(normalize(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	terms := make([]string, 0, len(fields))
+	for _, f := range fields {
+		terms = append(terms, stem(f))
+	}
+	return terms
+}
+
+// count how many times each term appears in s
+func termFreqs(s string) map[string]int {
+	freqs := make(map[string]int)
+	for _, term := range Tokenize(s) {
+		freqs[term]++
+	}
+	return freqs
+}
+
+// weight of a term found in df of n quotes, rare terms count for more
+func idf(n, df int64) float64 {
+	if df == 0 {
+		return 0
+	}
+	return math.Log(1 + float64(n)/float64(df))
+}
+
+// IndexTerms returns the terms a quote has to contain to possibly match
+// query, nil if the query can't be answered from the index
+func (query Query) IndexTerms() []string {
+	terms := make([]string, 0)
+	for _, term := range query.Terms {
+		if p, ok := term.(Phrase); ok {
+			terms = append(terms, Tokenize(p.Text)...)
+		}
+	}
+	if len(terms) == 0 {
+		return nil
+	}
+
+	// dedupe so a repeated word isn't weighted twice
+	sort.Strings(terms)
+	out := terms[:1]
+	for _, t := range terms[1:] {
+		if t != out[len(out)-1] {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// order results by score, ties go to the most recent quote
+func sortResults(results []SearchResult) {
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return dateScore(results[i].Quote) > dateScore(results[j].Quote)
+	})
+}
+
+// a vocabulary term close enough to a fuzzy search term, closeness is 1 for an exact match
+type fuzzyTerm struct {
+	Term      string
+	Closeness float64
+}
+
+// how many typos a term of this length tolerates
+func maxEdits(term string) int {
+	switch n := len([]rune(term)); {
+	case n <= 2:
+		return 0
+	case n <= 5:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// optimal string alignment distance, levenshtein where swapping two
+// adjacent letters is a single edit so "dgo" is one typo away from "dog"
+func osaDistance(a, b []rune) int {
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, minInt(cur[j-1]+1, prev[j-1]+cost))
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				cur[j] = minInt(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// trigrams of term padded with $ so the start and end of a word count too
+func trigrams(term string) []string {
+	runes := []rune("$" + term + "$")
+	out := make([]string, 0, len(runes))
+	seen := make(map[string]bool)
+	for i := 0; i+3 <= len(runes); i++ {
+		tri := string(runes[i : i+3])
+		if !seen[tri] {
+			seen[tri] = true
+			out = append(out, tri)
+		}
+	}
+	return out
+}
+
+// trigrams to look up fuzzy candidates for term by, a transposed pair can
+// leave a short term sharing no trigram with the word it was meant to be,
+// so the trigrams of every adjacent swap are included as well
+func fuzzyTrigrams(term string) []string {
+	out := trigrams(term)
+	seen := make(map[string]bool, len(out))
+	for _, tri := range out {
+		seen[tri] = true
+	}
+
+	runes := []rune(term)
+	for i := 0; i+1 < len(runes); i++ {
+		if runes[i] == runes[i+1] {
+			continue
+		}
+		swapped := append([]rune(nil), runes...)
+		swapped[i], swapped[i+1] = swapped[i+1], swapped[i]
+		for _, tri := range trigrams(string(swapped)) {
+			if !seen[tri] {
+				seen[tri] = true
+				out = append(out, tri)
+			}
+		}
+	}
+	return out
+}
+
+// the terms in vocab sharing one of term's fuzzyTrigrams, every backend only
+// edit checks these so a typo finds the same words whatever the store is
+func fuzzyCandidates(term string, vocab []string) []string {
+	tris := make(map[string]bool)
+	for _, tri := range fuzzyTrigrams(term) {
+		tris[tri] = true
+	}
+
+	out := make([]string, 0)
+	for _, v := range vocab {
+		for _, tri := range trigrams(v) {
+			if tris[tri] {
+				out = append(out, v)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// the terms in vocab within maxEdits of term, closest first
+func fuzzyMatch(term string, vocab []string) []fuzzyTerm {
+	want := []rune(term)
+	edits := maxEdits(term)
+
+	out := make([]fuzzyTerm, 0)
+	for _, v := range vocab {
+		have := []rune(v)
+		if diff := len(have) - len(want); diff > edits || -diff > edits {
+			continue
+		}
+
+		d := osaDistance(want, have)
+		if d > edits {
+			continue
+		}
+
+		longest := len(want)
+		if len(have) > longest {
+			longest = len(have)
+		}
+		out = append(out, fuzzyTerm{Term: v, Closeness: 1 - float64(d)/float64(longest)})
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Closeness > out[j].Closeness
+	})
+	return out
+}
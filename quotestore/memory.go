@@ -0,0 +1,388 @@
+package quotestore
+
+// in-memory backend, nothing is persisted between restarts
+// useful for running the api locally and in tests without a redis server
+// nothing blocks so the contexts passed to its methods are unused
+
+import (
+	"context"
+	crand "crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"steno/discord"
+)
+
+type memoryQuote struct {
+	quote  Quote
+	userID discord.Snowflake
+	revs   []Revision
+}
+
+// MemoryStore mirrors the redis layout, quotes are unique by id within a guild
+type MemoryStore struct {
+	mu     sync.RWMutex
+	guilds map[discord.Snowflake]map[string]*memoryQuote   // guildID -> quote.ID -> quote
+	index  map[discord.Snowflake]map[string]map[string]int // guildID -> term -> quote.ID -> term frequency
+	rng    *rand.Rand
+}
+
+func MemoryStoreNew() *MemoryStore {
+	return &MemoryStore{
+		guilds: make(map[discord.Snowflake]map[string]*memoryQuote),
+		index:  make(map[discord.Snowflake]map[string]map[string]int),
+		rng:    rand.New(rand.NewSource(randSeed())),
+	}
+}
+
+// caller must hold store.mu
+func (store *MemoryStore) indexQuote(guildID discord.Snowflake, quote Quote) {
+	if store.index[guildID] == nil {
+		store.index[guildID] = make(map[string]map[string]int)
+	}
+	for term, tf := range termFreqs(quote.Str) {
+		if store.index[guildID][term] == nil {
+			store.index[guildID][term] = make(map[string]int)
+		}
+		store.index[guildID][term][quote.ID] = tf
+	}
+}
+
+// caller must hold store.mu
+func (store *MemoryStore) unindexQuote(guildID discord.Snowflake, quote Quote) {
+	for term := range termFreqs(quote.Str) {
+		delete(store.index[guildID][term], quote.ID)
+		if len(store.index[guildID][term]) == 0 {
+			delete(store.index[guildID], term)
+		}
+	}
+	if len(store.index[guildID]) == 0 {
+		delete(store.index, guildID)
+	}
+}
+
+// seed from crypto/rand so separate instances started at the same time differ
+func randSeed() int64 {
+	var buf [8]byte
+	_, err := crand.Read(buf[:])
+	if err != nil {
+		return time.Now().UnixNano()
+	}
+	return int64(binary.LittleEndian.Uint64(buf[:]))
+}
+
+// lookup quoteID, returns nil if it doesn't belong to userID
+// caller must hold store.mu
+func (store *MemoryStore) get(guildID, userID discord.Snowflake, quoteID string) *memoryQuote {
+	mq, ok := store.guilds[guildID][quoteID]
+	if !ok || mq.userID != userID {
+		return nil
+	}
+	return mq
+}
+
+// caller must hold store.mu
+func (store *MemoryStore) rm(guildID discord.Snowflake, quoteID string) {
+	if mq, ok := store.guilds[guildID][quoteID]; ok {
+		store.unindexQuote(guildID, mq.quote)
+	}
+	delete(store.guilds[guildID], quoteID)
+	if len(store.guilds[guildID]) == 0 {
+		delete(store.guilds, guildID)
+	}
+}
+
+// sort quotes by date, oldest first, with the same tie break as the redis date index
+func sortByDate(quotes []Quote) {
+	sort.Slice(quotes, func(i, j int) bool {
+		si, sj := dateScore(quotes[i]), dateScore(quotes[j])
+		if si != sj {
+			return si < sj
+		}
+		return quotes[i].ID < quotes[j].ID
+	})
+}
+
+func (store *MemoryStore) Push(ctx context.Context, guildID, userID discord.Snowflake, quote Quote) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, ok := store.guilds[guildID][quote.ID]; ok {
+		return ErrDuplicateID
+	}
+	if store.guilds[guildID] == nil {
+		store.guilds[guildID] = make(map[string]*memoryQuote)
+	}
+	store.guilds[guildID][quote.ID] = &memoryQuote{quote: quote, userID: userID}
+	store.indexQuote(guildID, quote)
+	return nil
+}
+
+func (store *MemoryStore) Rm(ctx context.Context, guildID, userID discord.Snowflake, quote Quote) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	// only remove exact matches
+	if mq := store.get(guildID, userID, quote.ID); mq != nil && mq.quote.Equal(quote) {
+		store.rm(guildID, quote.ID)
+	}
+	return nil
+}
+
+func (store *MemoryStore) RmByID(ctx context.Context, guildID, userID discord.Snowflake, quoteID string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if store.get(guildID, userID, quoteID) == nil {
+		return ErrNotFound
+	}
+	store.rm(guildID, quoteID)
+	return nil
+}
+
+func (store *MemoryStore) GetByID(ctx context.Context, guildID, userID discord.Snowflake, quoteID string) (Quote, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	mq := store.get(guildID, userID, quoteID)
+	if mq == nil {
+		return Quote{}, ErrNotFound
+	}
+	return mq.quote, nil
+}
+
+func (store *MemoryStore) Edit(ctx context.Context, guildID, userID discord.Snowflake, quoteID string, patch QuotePatch) (Quote, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	mq := store.get(guildID, userID, quoteID)
+	if mq == nil {
+		return Quote{}, ErrNotFound
+	}
+
+	edited, rev := patch.Apply(mq.quote)
+	if len(rev.Fields) == 0 {
+		return edited, nil
+	}
+
+	store.unindexQuote(guildID, mq.quote)
+	store.indexQuote(guildID, edited)
+	mq.quote = edited
+	mq.revs = append(mq.revs, rev)
+	return edited, nil
+}
+
+func (store *MemoryStore) Revisions(ctx context.Context, guildID, userID discord.Snowflake, quoteID string) ([]Revision, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	mq := store.get(guildID, userID, quoteID)
+	if mq == nil {
+		return nil, ErrNotFound
+	}
+
+	out := make([]Revision, len(mq.revs))
+	copy(out, mq.revs)
+	return out, nil
+}
+
+// rank the quotes owned by userID, or anyone if userID is zero, with
+// the same tf-idf and fuzzy scoring as the redis backend
+func (store *MemoryStore) search(guildID, userID discord.Snowflake, query Query) []SearchResult {
+	terms := query.IndexTerms()
+	if terms == nil {
+		// only filters, nothing to look up in the index
+		list := store.list(guildID, userID)
+		results := make([]SearchResult, len(list))
+		for i, quote := range list {
+			results[i] = SearchResult{Quote: quote}
+		}
+		return searchQuotes(results, query)
+	}
+
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	groups := make([][]fuzzyTerm, len(terms))
+	for i, term := range terms {
+		if query.Fuzzy {
+			vocab := make([]string, 0, len(store.index[guildID]))
+			for v := range store.index[guildID] {
+				vocab = append(vocab, v)
+			}
+			groups[i] = fuzzyMatch(term, fuzzyCandidates(term, vocab))
+		} else {
+			groups[i] = []fuzzyTerm{{Term: term, Closeness: 1}}
+		}
+	}
+
+	total := int64(len(store.guilds[guildID]))
+	scores := make(map[string]float64)
+	for i, group := range groups {
+		// a group's score is its best term's closeness * tf-idf
+		best := make(map[string]float64)
+		for _, ft := range group {
+			postings := store.index[guildID][ft.Term]
+			weight := ft.Closeness * idf(total, int64(len(postings)))
+			for id, tf := range postings {
+				best[id] = math.Max(best[id], weight*float64(tf))
+			}
+		}
+
+		// every group has to match, only keep quotes seen for all of them
+		next := make(map[string]float64)
+		for id, score := range best {
+			if prev, ok := scores[id]; ok || i == 0 {
+				next[id] = prev + score
+			}
+		}
+		scores = next
+	}
+
+	results := make([]SearchResult, 0, len(scores))
+	for id, score := range scores {
+		mq := store.guilds[guildID][id]
+		if userID == 0 || mq.userID == userID {
+			results = append(results, SearchResult{Quote: mq.quote, Score: score})
+		}
+	}
+	return searchQuotes(results, query)
+}
+
+func (store *MemoryStore) Search(ctx context.Context, guildID, userID discord.Snowflake, query Query) ([]SearchResult, error) {
+	return store.search(guildID, userID, query), nil
+}
+
+func (store *MemoryStore) GuildSearch(ctx context.Context, guildID discord.Snowflake, query Query) ([]SearchResult, error) {
+	return store.search(guildID, 0, query), nil
+}
+
+// every quote in the guild owned by userID or every user if userID is zero
+func (store *MemoryStore) list(guildID, userID discord.Snowflake) []Quote {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	out := make([]Quote, 0)
+	for _, mq := range store.guilds[guildID] {
+		if userID == 0 || mq.userID == userID {
+			out = append(out, mq.quote)
+		}
+	}
+
+	sortByDate(out)
+	return out
+}
+
+// pick up to count distinct quotes from quoteList
+func (store *MemoryStore) random(quoteList []Quote, count int) []Quote {
+	if count < 1 {
+		count = 1
+	} else if count > len(quoteList) {
+		count = len(quoteList)
+	}
+
+	// rand.Rand is not safe for concurrent use
+	store.mu.Lock()
+	choices := store.rng.Perm(len(quoteList))[:count]
+	store.mu.Unlock()
+
+	out := make([]Quote, count)
+	for i, choice := range choices {
+		out[i] = quoteList[choice]
+	}
+	return out
+}
+
+func (store *MemoryStore) page(guildID, userID discord.Snowflake, opts PageOptions) (QuotePage, error) {
+	c, err := opts.decode()
+	if err != nil {
+		return QuotePage{}, err
+	}
+
+	quotes := store.list(guildID, userID)
+	if opts.Sort == SortDesc {
+		for i, j := 0, len(quotes)-1; i < j; i, j = i+1, j-1 {
+			quotes[i], quotes[j] = quotes[j], quotes[i]
+		}
+	}
+
+	// skip everything up to and including the cursor's (date, id)
+	start := 0
+	if c.ID != "" {
+		start = sort.Search(len(quotes), func(i int) bool {
+			score := dateScore(quotes[i])
+			if opts.Sort == SortDesc {
+				return score < c.Score || (score == c.Score && quotes[i].ID < c.ID)
+			}
+			return score > c.Score || (score == c.Score && quotes[i].ID > c.ID)
+		})
+	}
+
+	quotes = quotes[start:]
+	var next string
+	if len(quotes) > opts.Limit {
+		quotes = quotes[:opts.Limit]
+		last := quotes[len(quotes)-1]
+		next = encodeCursor(pageCursor{Sort: opts.Sort, ID: last.ID, Score: dateScore(last)})
+	}
+
+	return QuotePage{Quotes: quotes, NextCursor: next}, nil
+}
+
+func (store *MemoryStore) List(ctx context.Context, guildID, userID discord.Snowflake, opts PageOptions) (QuotePage, error) {
+	return store.page(guildID, userID, opts)
+}
+
+func (store *MemoryStore) GuildList(ctx context.Context, guildID discord.Snowflake, opts PageOptions) (QuotePage, error) {
+	return store.page(guildID, 0, opts)
+}
+
+func (store *MemoryStore) GetAll(ctx context.Context, guildID, userID discord.Snowflake) ([]Quote, error) {
+	out := store.list(guildID, userID)
+	if len(out) == 0 {
+		return nil, fmt.Errorf("memorystore: No quotes for guildID:%s userID:%s", guildID, userID)
+	}
+	return out, nil
+}
+
+func (store *MemoryStore) GetRandom(ctx context.Context, guildID, userID discord.Snowflake, count int) ([]Quote, error) {
+	quoteList, err := store.GetAll(ctx, guildID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return store.random(quoteList, count), nil
+}
+
+func (store *MemoryStore) GuildGetAll(ctx context.Context, guildID discord.Snowflake) ([]Quote, error) {
+	out := store.list(guildID, 0)
+	if len(out) == 0 {
+		return nil, fmt.Errorf("memorystore: No quotes for guildID:%s", guildID)
+	}
+	return out, nil
+}
+
+func (store *MemoryStore) GuildGetRandom(ctx context.Context, guildID discord.Snowflake, count int) ([]Quote, error) {
+	quoteList, err := store.GuildGetAll(ctx, guildID)
+	if err != nil {
+		return nil, err
+	}
+	return store.random(quoteList, count), nil
+}
+
+func (store *MemoryStore) GuildCounts(ctx context.Context) (map[string]int64, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	out := make(map[string]int64, len(store.guilds))
+	for guildID, quotes := range store.guilds {
+		if len(quotes) > 0 {
+			out[guildID.String()] = int64(len(quotes))
+		}
+	}
+	return out, nil
+}
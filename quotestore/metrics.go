@@ -0,0 +1,145 @@
+package quotestore
+
+// MeteredStore times every call to another QuoteStore
+//
+//	steno_store_operation_duration_seconds{op}  histogram of call latencies
+//	steno_store_operation_errors_total{op}      calls that failed
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"steno/discord"
+	"steno/metrics"
+)
+
+var operationDuration = metrics.Default.Histogram("steno_store_operation_duration_seconds",
+	"Time taken by quote store operations.", nil, "op")
+var operationErrors = metrics.Default.Counter("steno_store_operation_errors_total",
+	"Quote store operations that failed.", "op")
+
+// MeteredStore records the latency and errors of every operation of the
+// store it wraps in metrics.Default
+type MeteredStore struct {
+	store QuoteStore
+}
+
+func MeteredStoreNew(store QuoteStore) MeteredStore {
+	return MeteredStore{store: store}
+}
+
+// record a call that started at start, missing quotes, duplicate ids and bad
+// cursors are answers rather than failures so they aren't counted as errors
+func observe(op string, start time.Time, err error) {
+	operationDuration.Since(start, op)
+	if err != nil && !errors.Is(err, ErrNotFound) &&
+		!errors.Is(err, ErrDuplicateID) && !errors.Is(err, ErrInvalidCursor) {
+		operationErrors.Inc(op)
+	}
+}
+
+func (m MeteredStore) GetAll(ctx context.Context, guildID, userID discord.Snowflake) ([]Quote, error) {
+	start := time.Now()
+	quotes, err := m.store.GetAll(ctx, guildID, userID)
+	observe("get_all", start, err)
+	return quotes, err
+}
+
+func (m MeteredStore) GetRandom(ctx context.Context, guildID, userID discord.Snowflake, count int) ([]Quote, error) {
+	start := time.Now()
+	quotes, err := m.store.GetRandom(ctx, guildID, userID, count)
+	observe("get_random", start, err)
+	return quotes, err
+}
+
+func (m MeteredStore) GetByID(ctx context.Context, guildID, userID discord.Snowflake, quoteID string) (Quote, error) {
+	start := time.Now()
+	quote, err := m.store.GetByID(ctx, guildID, userID, quoteID)
+	observe("get_by_id", start, err)
+	return quote, err
+}
+
+func (m MeteredStore) Search(ctx context.Context, guildID, userID discord.Snowflake, query Query) ([]SearchResult, error) {
+	start := time.Now()
+	results, err := m.store.Search(ctx, guildID, userID, query)
+	observe("search", start, err)
+	return results, err
+}
+
+func (m MeteredStore) Push(ctx context.Context, guildID, userID discord.Snowflake, quote Quote) error {
+	start := time.Now()
+	err := m.store.Push(ctx, guildID, userID, quote)
+	observe("push", start, err)
+	return err
+}
+
+func (m MeteredStore) Rm(ctx context.Context, guildID, userID discord.Snowflake, quote Quote) error {
+	start := time.Now()
+	err := m.store.Rm(ctx, guildID, userID, quote)
+	observe("rm", start, err)
+	return err
+}
+
+func (m MeteredStore) RmByID(ctx context.Context, guildID, userID discord.Snowflake, quoteID string) error {
+	start := time.Now()
+	err := m.store.RmByID(ctx, guildID, userID, quoteID)
+	observe("rm_by_id", start, err)
+	return err
+}
+
+func (m MeteredStore) Edit(ctx context.Context, guildID, userID discord.Snowflake, quoteID string, patch QuotePatch) (Quote, error) {
+	start := time.Now()
+	quote, err := m.store.Edit(ctx, guildID, userID, quoteID, patch)
+	observe("edit", start, err)
+	return quote, err
+}
+
+func (m MeteredStore) Revisions(ctx context.Context, guildID, userID discord.Snowflake, quoteID string) ([]Revision, error) {
+	start := time.Now()
+	revs, err := m.store.Revisions(ctx, guildID, userID, quoteID)
+	observe("revisions", start, err)
+	return revs, err
+}
+
+func (m MeteredStore) List(ctx context.Context, guildID, userID discord.Snowflake, opts PageOptions) (QuotePage, error) {
+	start := time.Now()
+	page, err := m.store.List(ctx, guildID, userID, opts)
+	observe("list", start, err)
+	return page, err
+}
+
+func (m MeteredStore) GuildGetAll(ctx context.Context, guildID discord.Snowflake) ([]Quote, error) {
+	start := time.Now()
+	quotes, err := m.store.GuildGetAll(ctx, guildID)
+	observe("guild_get_all", start, err)
+	return quotes, err
+}
+
+func (m MeteredStore) GuildGetRandom(ctx context.Context, guildID discord.Snowflake, count int) ([]Quote, error) {
+	start := time.Now()
+	quotes, err := m.store.GuildGetRandom(ctx, guildID, count)
+	observe("guild_get_random", start, err)
+	return quotes, err
+}
+
+func (m MeteredStore) GuildSearch(ctx context.Context, guildID discord.Snowflake, query Query) ([]SearchResult, error) {
+	start := time.Now()
+	results, err := m.store.GuildSearch(ctx, guildID, query)
+	observe("guild_search", start, err)
+	return results, err
+}
+
+func (m MeteredStore) GuildList(ctx context.Context, guildID discord.Snowflake, opts PageOptions) (QuotePage, error) {
+	start := time.Now()
+	page, err := m.store.GuildList(ctx, guildID, opts)
+	observe("guild_list", start, err)
+	return page, err
+}
+
+func (m MeteredStore) GuildCounts(ctx context.Context) (map[string]int64, error) {
+	start := time.Now()
+	counts, err := m.store.GuildCounts(ctx)
+	observe("guild_counts", start, err)
+	return counts, err
+}
@@ -0,0 +1,293 @@
+package quotestore
+
+// search query language
+//
+//	words              quotes containing the words in order, case, accents, punctuation
+//	                   and word endings are ignored so "Running" matches "run"
+//	"quoted words"     an explicit phrase, useful to keep a phrase next to a filter
+//	author:ID          quotes said by ID, a discord mention <@ID> also works
+//	stenographer:ID    quotes recorded by ID
+//	before:DATE        quotes dated before DATE, YYYY-MM-DD or RFC3339
+//	after:DATE         quotes dated on or after DATE
+//	tag:NAME           quotes tagged NAME
+//	re:PATTERN         quotes whose text matches the regular expression PATTERN
+//	-term              negates any of the above
+//
+// every term has to match, values with spaces can be quoted e.g. re:"a b+"
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Node is a single term of a parsed query
+type Node interface {
+	Match(q Quote) bool
+}
+
+type Phrase struct{ Text string }
+type Regex struct{ Re *regexp.Regexp }
+type Author struct{ ID string }
+type Stenographer struct{ ID string }
+type Before struct{ Time time.Time }
+type After struct{ Time time.Time }
+type Tag struct{ Name string }
+type Not struct{ Node Node }
+
+// Query is the parsed form of a search string, a quote matches if every term matches
+type Query struct {
+	Terms []Node
+	Raw   string
+
+	// Fuzzy lets the words of phrases match index terms with typos,
+	// word order is not enforced in fuzzy mode
+	Fuzzy bool
+}
+
+func (n Phrase) Match(q Quote) bool {
+	want := Tokenize(n.Text)
+	if len(want) == 0 {
+		// nothing but punctuation, match it literally
+		return strings.Contains(normalize(q.Str), normalize(n.Text))
+	}
+
+	have := Tokenize(q.Str)
+	for i := 0; i+len(want) <= len(have); i++ {
+		j := 0
+		for j < len(want) && have[i+j] == want[j] {
+			j++
+		}
+		if j == len(want) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n Regex) Match(q Quote) bool {
+	return n.Re.MatchString(q.Str)
+}
+
+func (n Author) Match(q Quote) bool {
+	return q.AuthorID == n.ID
+}
+
+func (n Stenographer) Match(q Quote) bool {
+	return q.StenographerID == n.ID
+}
+
+func (n Before) Match(q Quote) bool {
+	t, err := time.Parse(time.RFC3339, q.Date)
+	return err == nil && t.Before(n.Time)
+}
+
+func (n After) Match(q Quote) bool {
+	t, err := time.Parse(time.RFC3339, q.Date)
+	return err == nil && !t.Before(n.Time)
+}
+
+func (n Tag) Match(q Quote) bool {
+	for _, tag := range q.Tags {
+		if strings.EqualFold(tag, n.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n Not) Match(q Quote) bool {
+	return !n.Node.Match(q)
+}
+
+func (query Query) Match(q Quote) bool {
+	for _, term := range query.Terms {
+		if !term.Match(q) {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchFilters is Match without the phrases, used when the phrases were
+// already matched loosely against the index
+func (query Query) MatchFilters(q Quote) bool {
+	for _, term := range query.Terms {
+		if _, ok := term.(Phrase); ok {
+			continue
+		}
+		if !term.Match(q) {
+			return false
+		}
+	}
+	return true
+}
+
+func (query Query) String() string {
+	return query.Raw
+}
+
+// DateRange returns the unix date bounds implied by the query's top level
+// before and after terms, backends can use them to narrow their date index
+func (query Query) DateRange() (min, max float64) {
+	min, max = math.Inf(-1), math.Inf(1)
+	for _, term := range query.Terms {
+		switch n := term.(type) {
+		case After:
+			min = math.Max(min, float64(n.Time.Unix()))
+		case Before:
+			// before is exclusive, dates are stored to the second
+			max = math.Min(max, float64(n.Time.Unix()-1))
+		}
+	}
+	return min, max
+}
+
+func parseDate(s string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, s)
+	if err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", s)
+}
+
+// strip the <@ID> or <@!ID> discord mention syntax
+func parseUserID(s string) string {
+	if strings.HasPrefix(s, "<@") && strings.HasSuffix(s, ">") {
+		s = strings.TrimPrefix(s[2:len(s)-1], "!")
+	}
+	return s
+}
+
+func parseField(field, value string) (Node, error) {
+	if value == "" {
+		return nil, fmt.Errorf("%s: needs a value", field)
+	}
+
+	switch field {
+	case "author":
+		return Author{ID: parseUserID(value)}, nil
+	case "stenographer":
+		return Stenographer{ID: parseUserID(value)}, nil
+	case "tag":
+		return Tag{Name: value}, nil
+	case "before", "after":
+		t, err := parseDate(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: expected a date like 2006-01-02, got %q", field, value)
+		}
+		if field == "before" {
+			return Before{Time: t}, nil
+		}
+		return After{Time: t}, nil
+	case "re":
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return nil, fmt.Errorf("re: %s", err)
+		}
+		return Regex{Re: re}, nil
+	}
+	return nil, fmt.Errorf("unknown field %s", field)
+}
+
+var queryFields = map[string]bool{
+	"author": true, "stenographer": true, "before": true, "after": true, "tag": true, "re": true,
+}
+
+type queryLexer struct {
+	s   []rune
+	pos int
+}
+
+func (l *queryLexer) skipSpace() {
+	for l.pos < len(l.s) && unicode.IsSpace(l.s[l.pos]) {
+		l.pos++
+	}
+}
+
+// read a "quoted" string or a bare word up to the next space
+func (l *queryLexer) value() (string, bool, error) {
+	if l.pos < len(l.s) && l.s[l.pos] == '"' {
+		end := l.pos + 1
+		for end < len(l.s) && l.s[end] != '"' {
+			end++
+		}
+		if end == len(l.s) {
+			return "", true, errors.New("unterminated quote")
+		}
+		v := string(l.s[l.pos+1 : end])
+		l.pos = end + 1
+		return v, true, nil
+	}
+
+	start := l.pos
+	for l.pos < len(l.s) && !unicode.IsSpace(l.s[l.pos]) {
+		l.pos++
+	}
+	return string(l.s[start:l.pos]), false, nil
+}
+
+// ParseQuery parses a search string, see the top of this file for the syntax
+func ParseQuery(s string) (Query, error) {
+	query := Query{Raw: s}
+	l := queryLexer{s: []rune(s)}
+
+	// consecutive bare words are matched as one phrase
+	words := make([]string, 0)
+	flushWords := func() {
+		if len(words) > 0 {
+			query.Terms = append(query.Terms, Phrase{Text: strings.Join(words, " ")})
+			words = words[:0]
+		}
+	}
+
+	for l.skipSpace(); l.pos < len(l.s); l.skipSpace() {
+		negate := false
+		if l.s[l.pos] == '-' && l.pos+1 < len(l.s) && !unicode.IsSpace(l.s[l.pos+1]) {
+			negate = true
+			l.pos++
+		}
+
+		var node Node
+		start := l.pos
+		word, quoted, err := l.value()
+		if err != nil {
+			return Query{}, fmt.Errorf("invalid query, %s", err)
+		}
+
+		field := strings.ToLower(strings.SplitN(word, ":", 2)[0])
+		if !quoted && strings.Contains(word, ":") && queryFields[field] {
+			// rewind to read the value, it may be quoted
+			l.pos = start + len([]rune(field)) + 1
+			var v string
+			v, _, err = l.value()
+			if err == nil {
+				node, err = parseField(field, v)
+			}
+			if err != nil {
+				return Query{}, fmt.Errorf("invalid query, %s", err)
+			}
+		} else if quoted || negate {
+			node = Phrase{Text: word}
+		} else {
+			words = append(words, word)
+			continue
+		}
+
+		flushWords()
+		if negate {
+			node = Not{Node: node}
+		}
+		query.Terms = append(query.Terms, node)
+	}
+	flushWords()
+
+	if len(query.Terms) == 0 {
+		return Query{}, errors.New("invalid query, empty")
+	}
+	return query, nil
+}
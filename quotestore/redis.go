@@ -7,126 +7,955 @@ package quotestore
 // redis could be used as a caching front layer to a more
 // proper relational database to lessen the join queries on the db
 
+// key schema
+//	quote:{guild}:{id}            hash of the quote's fields
+//	quote:{guild}:{id}:revisions  list of revision json, oldest first
+//	quotes:{guild}:{user}:ids     set of the user's quote ids
+//	quotes:{guild}:{user}:by_date sorted set of the user's quote ids scored by unix date
+//	quotes:{guild}:ids            set of every quote id in the guild
+//	quotes:{guild}:by_date        sorted set of every quote id in the guild scored by unix date
+//	guilds                        set of guild ids that have quotes
+//	index:{guild}:{term}          sorted set of the ids of quotes containing term scored by term frequency
+//	trigrams:{guild}:{trigram}    set of the index terms containing trigram, used by fuzzy search
+//	index_version                 version of the term index, see Migrate
+
 import (
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
-	"math/rand"
+	"math"
 	"os"
-	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+
+	"steno/discord"
 )
 
 type RedisStore struct {
-	ctx context.Context
-	db  *redis.Client
+	db *redis.Client
+}
+
+// prefix for the per user id indexes
+func quotesURI(guildID, userID discord.Snowflake) string {
+	return fmt.Sprintf("quotes:%s:%s", guildID, userID)
+}
+
+// prefix for the per guild id indexes
+func guildQuotesURI(guildID discord.Snowflake) string {
+	return fmt.Sprintf("quotes:%s", guildID)
 }
 
-func quotesURI(guildID, userID string) string {
+func quoteURI(guildID discord.Snowflake, quoteID string) string {
+	return fmt.Sprintf("quote:%s:%s", guildID, quoteID)
+}
+
+// list of revision json for a single quote, oldest first
+func revisionsURI(guildID discord.Snowflake, quoteID string) string {
+	return quoteURI(guildID, quoteID) + ":revisions"
+}
+
+const guildsURI = "guilds"
+
+// postings for a term of the full text index
+func indexURI(guildID discord.Snowflake, term string) string {
+	return fmt.Sprintf("index:%s:%s", guildID, term)
+}
+
+// vocabulary of index terms by trigram, a term is removed once its last
+// quote is, see lastTerms
+func trigramURI(guildID discord.Snowflake, trigram string) string {
+	return fmt.Sprintf("trigrams:%s:%s", guildID, trigram)
+}
+
+// bump when Tokenize or the index layout changes so Migrate rebuilds the index
+const indexVersion = 1
+const indexVersionURI = "index_version"
+
+// each user's quotes used to be one list of quote json, see Migrate
+func legacyQuotesURI(guildID, userID discord.Snowflake) string {
 	return fmt.Sprintf("%s:%s:quotes", guildID, userID)
 }
 
 func Connect(addr string, pass string, nDB int) RedisStore {
-	var ctx = context.Background()
 	var db = redis.NewClient(&redis.Options{
 		Addr:     addr,
 		Password: pass,
 		DB:       nDB,
 	})
 
-	return RedisStore{ctx: ctx, db: db}
+	return RedisStore{db: db}
+}
+
+// Ping checks that redis is reachable
+func (store RedisStore) Ping(ctx context.Context) error {
+	return store.db.Ping(ctx).Err()
+}
+
+func quoteHash(guildID, userID discord.Snowflake, q Quote) map[string]interface{} {
+	tags, _ := json.Marshal(q.Tags)
+	return map[string]interface{}{
+		"tags":            tags,
+		"id":              q.ID,
+		"guild_id":        guildID.String(),
+		"user_id":         userID.String(),
+		"author_id":       q.AuthorID,
+		"str":             q.Str,
+		"date":            q.Date,
+		"stenographer_id": q.StenographerID,
+		"message_id":      q.MessageID,
+		"channel_id":      q.ChannelID,
+	}
 }
 
-func (store RedisStore) Push(guildID, userID string, quote Quote) error {
-	uri := quotesURI(guildID, userID)
+func quoteFromHash(h map[string]string) Quote {
+	q := Quote{
+		ID:             h["id"],
+		AuthorID:       h["author_id"],
+		Str:            h["str"],
+		Date:           h["date"],
+		StenographerID: h["stenographer_id"],
+		MessageID:      h["message_id"],
+		ChannelID:      h["channel_id"],
+	}
+	json.Unmarshal([]byte(h["tags"]), &q.Tags)
+	return q
+}
 
-	quoteJSON, err := json.Marshal(quote)
+// score for the date indexes, quotes with a date that can't be parsed sort first
+func dateScore(q Quote) float64 {
+	t, err := time.Parse(time.RFC3339, q.Date)
 	if err != nil {
-		return err
+		return 0
 	}
+	return float64(t.Unix())
+}
 
-	_, err = store.db.RPush(store.ctx, uri, quoteJSON).Result()
-	return err
+// format a score for the range commands, which expect -inf and +inf for unbounded ranges
+func scoreString(score float64) string {
+	if math.IsInf(score, -1) {
+		return "-inf"
+	} else if math.IsInf(score, 1) {
+		return "+inf"
+	}
+	return strconv.FormatFloat(score, 'f', -1, 64)
+}
+
+// queue the writes that add quote to every index
+func pushQuote(ctx context.Context, pipe redis.Pipeliner, guildID, userID discord.Snowflake, quote Quote) {
+	score := dateScore(quote)
+	pipe.HSet(ctx, quoteURI(guildID, quote.ID), quoteHash(guildID, userID, quote))
+	pipe.SAdd(ctx, quotesURI(guildID, userID)+":ids", quote.ID)
+	pipe.ZAdd(ctx, quotesURI(guildID, userID)+":by_date", &redis.Z{Score: score, Member: quote.ID})
+	pipe.SAdd(ctx, guildQuotesURI(guildID)+":ids", quote.ID)
+	pipe.ZAdd(ctx, guildQuotesURI(guildID)+":by_date", &redis.Z{Score: score, Member: quote.ID})
+	pipe.SAdd(ctx, guildsURI, guildID.String())
+	indexQuote(ctx, pipe, guildID, quote)
+}
+
+// queue the writes that add quote's terms to the index
+func indexQuote(ctx context.Context, pipe redis.Pipeliner, guildID discord.Snowflake, quote Quote) {
+	for term, tf := range termFreqs(quote.Str) {
+		pipe.ZAdd(ctx, indexURI(guildID, term), &redis.Z{Score: float64(tf), Member: quote.ID})
+		for _, tri := range trigrams(term) {
+			pipe.SAdd(ctx, trigramURI(guildID, tri), term)
+		}
+	}
+}
+
+// queue the writes that remove quote's terms from the index, last are the
+// terms only quote contains which also leave the trigram vocabulary
+func unindexQuote(ctx context.Context, pipe redis.Pipeliner, guildID discord.Snowflake, quote Quote, last []string) {
+	for term := range termFreqs(quote.Str) {
+		pipe.ZRem(ctx, indexURI(guildID, term), quote.ID)
+	}
+	for _, term := range last {
+		for _, tri := range trigrams(term) {
+			pipe.SRem(ctx, trigramURI(guildID, tri), term)
+		}
+	}
+}
+
+// the terms of quote that no other quote contains, the index keys are
+// watched so a quote adding one of them before tx commits retries it
+func lastTerms(ctx context.Context, tx *redis.Tx, guildID discord.Snowflake, quote Quote) ([]string, error) {
+	terms := make([]string, 0)
+	keys := make([]string, 0)
+	for term := range termFreqs(quote.Str) {
+		terms = append(terms, term)
+		keys = append(keys, indexURI(guildID, term))
+	}
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	if err := tx.Watch(ctx, keys...).Err(); err != nil {
+		return nil, err
+	}
+
+	pipe := tx.Pipeline()
+	cards := make([]*redis.IntCmd, len(keys))
+	for i, key := range keys {
+		cards[i] = pipe.ZCard(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	last := make([]string, 0)
+	for i, card := range cards {
+		if card.Val() <= 1 {
+			last = append(last, terms[i])
+		}
+	}
+	return last, nil
+}
+
+// queue the writes that remove quote from every index
+func rmQuote(ctx context.Context, pipe redis.Pipeliner, guildID, userID discord.Snowflake, quote Quote, last []string) {
+	quoteID := quote.ID
+	unindexQuote(ctx, pipe, guildID, quote, last)
+	pipe.Del(ctx, quoteURI(guildID, quoteID), revisionsURI(guildID, quoteID))
+	pipe.SRem(ctx, quotesURI(guildID, userID)+":ids", quoteID)
+	pipe.ZRem(ctx, quotesURI(guildID, userID)+":by_date", quoteID)
+	pipe.SRem(ctx, guildQuotesURI(guildID)+":ids", quoteID)
+	pipe.ZRem(ctx, guildQuotesURI(guildID)+":by_date", quoteID)
+}
+
+// times a transaction is attempted before giving up on a busy quote
+const maxTxAttempts = 5
+
+// run fn in a transaction watching keys, when another client writes a watched
+// key first the transaction is dropped by redis and fn is run again
+func (store RedisStore) watch(ctx context.Context, fn func(tx *redis.Tx) error, keys ...string) error {
+	for attempt := 0; attempt < maxTxAttempts; attempt++ {
+		err := store.db.Watch(ctx, fn, keys...)
+		if err != redis.TxFailedErr {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	return fmt.Errorf("redisstore: %s changed during %d attempts: %w", strings.Join(keys, " "), maxTxAttempts, redis.TxFailedErr)
+}
+
+func (store RedisStore) Push(ctx context.Context, guildID, userID discord.Snowflake, quote Quote) error {
+	key := quoteURI(guildID, quote.ID)
+
+	return store.watch(ctx, func(tx *redis.Tx) error {
+		exists, err := tx.Exists(ctx, key).Result()
+		if err != nil {
+			return err
+		} else if exists > 0 {
+			return ErrDuplicateID
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pushQuote(ctx, pipe, guildID, userID, quote)
+			return nil
+		})
+		return err
+	}, key)
 }
 
-func (store RedisStore) Rm(guildID, userID string, quote Quote) error {
-	uri := quotesURI(guildID, userID)
-	quoteJSON, err := json.Marshal(quote)
+// fetch the hash for quoteID, returns ErrNotFound if it doesn't belong to userID
+func (store RedisStore) getHash(ctx context.Context, c redis.Cmdable, guildID, userID discord.Snowflake, quoteID string) (map[string]string, error) {
+	h, err := c.HGetAll(ctx, quoteURI(guildID, quoteID)).Result()
 	if err != nil {
+		return nil, err
+	}
+
+	if len(h) == 0 || h["user_id"] != userID.String() {
+		return nil, ErrNotFound
+	}
+	return h, nil
+}
+
+func (store RedisStore) Rm(ctx context.Context, guildID, userID discord.Snowflake, quote Quote) error {
+	key := quoteURI(guildID, quote.ID)
+
+	return store.watch(ctx, func(tx *redis.Tx) error {
+		h, err := store.getHash(ctx, tx, guildID, userID, quote.ID)
+		if err == ErrNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		// only remove exact matches
+		if !quoteFromHash(h).Equal(quote) {
+			return nil
+		}
+		last, err := lastTerms(ctx, tx, guildID, quote)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			rmQuote(ctx, pipe, guildID, userID, quote, last)
+			return nil
+		})
+		return err
+	}, key)
+}
+
+func (store RedisStore) RmByID(ctx context.Context, guildID, userID discord.Snowflake, quoteID string) error {
+	key := quoteURI(guildID, quoteID)
+
+	return store.watch(ctx, func(tx *redis.Tx) error {
+		h, err := store.getHash(ctx, tx, guildID, userID, quoteID)
+		if err != nil {
+			return err
+		}
+		quote := quoteFromHash(h)
+		last, err := lastTerms(ctx, tx, guildID, quote)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			rmQuote(ctx, pipe, guildID, userID, quote, last)
+			return nil
+		})
 		return err
+	}, key)
+}
+
+func (store RedisStore) Edit(ctx context.Context, guildID, userID discord.Snowflake, quoteID string, patch QuotePatch) (Quote, error) {
+	key := quoteURI(guildID, quoteID)
+
+	var edited Quote
+	// watch the hash so concurrent edits of the same quote can't interleave
+	err := store.watch(ctx, func(tx *redis.Tx) error {
+		h, err := store.getHash(ctx, tx, guildID, userID, quoteID)
+		if err != nil {
+			return err
+		}
+
+		old := quoteFromHash(h)
+		var rev Revision
+		edited, rev = patch.Apply(old)
+		if len(rev.Fields) == 0 {
+			return nil
+		}
+
+		revJSON, err := json.Marshal(rev)
+		if err != nil {
+			return err
+		}
+		// terms the edit keeps are added back to the trigrams by pushQuote
+		last, err := lastTerms(ctx, tx, guildID, old)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			// re-adding updates the date and term indexes
+			unindexQuote(ctx, pipe, guildID, old, last)
+			pushQuote(ctx, pipe, guildID, userID, edited)
+			pipe.RPush(ctx, revisionsURI(guildID, quoteID), revJSON)
+			return nil
+		})
+		return err
+	}, key)
+
+	if err != nil {
+		return Quote{}, err
 	}
+	return edited, nil
+}
 
-	_, err = store.db.LRem(store.ctx, uri, 0, quoteJSON).Result()
-	return err
+func (store RedisStore) Revisions(ctx context.Context, guildID, userID discord.Snowflake, quoteID string) ([]Revision, error) {
+	_, err := store.getHash(ctx, store.db, guildID, userID, quoteID)
+	if err != nil {
+		return nil, err
+	}
 
+	revs, err := store.db.LRange(ctx, revisionsURI(guildID, quoteID), 0, -1).Result()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	out := make([]Revision, 0, len(revs))
+	for _, r := range revs {
+		var rev Revision
+		err := json.Unmarshal([]byte(r), &rev)
+		if err != nil {
+			log.Printf("redisstore: error unmarshaling revision from db -- %s\n", err)
+			continue
+		}
+		out = append(out, rev)
+	}
+	return out, nil
 }
 
-func (store RedisStore) Search(guildID, userID, pattern string) ([]Quote, error) {
-	// very unoptimal searching but redis is unoptimal for this application
-	list, err := store.GetAll(guildID, userID)
+func (store RedisStore) GetByID(ctx context.Context, guildID, userID discord.Snowflake, quoteID string) (Quote, error) {
+	h, err := store.getHash(ctx, store.db, guildID, userID, quoteID)
 	if err != nil {
+		return Quote{}, err
+	}
+
+	return quoteFromHash(h), nil
+}
+
+// quotes from the date index at key that match query, the query's date
+// bounds are used to only read the part of the index that could match
+func (store RedisStore) scan(ctx context.Context, guildID discord.Snowflake, key string, query Query) ([]SearchResult, error) {
+	min, max := query.DateRange()
+	ids, err := store.db.ZRangeByScore(ctx, key+":by_date", &redis.ZRangeBy{
+		Min: scoreString(min),
+		Max: scoreString(max),
+	}).Result()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	list, err := store.quotesFromIDs(ctx, guildID, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]SearchResult, len(list))
+	for i, quote := range list {
+		results[i] = SearchResult{Quote: quote}
+	}
+	return searchQuotes(results, query), nil
+}
+
+// rank the quotes in the date index at key containing every term by tf-idf,
+// the scoring is done by redis with ZINTERSTORE
+func (store RedisStore) searchIndex(ctx context.Context, guildID discord.Snowflake, key string, terms []string, query Query) ([]SearchResult, error) {
+	expanded := make([][]fuzzyTerm, len(terms))
+	for i, term := range terms {
+		expanded[i] = []fuzzyTerm{{Term: term, Closeness: 1}}
+	}
+	return store.rank(ctx, guildID, key, expanded, query)
+}
+
+// like searchIndex but each term may match any vocabulary term within a few
+// typos, closer terms are weighted higher
+func (store RedisStore) searchFuzzy(ctx context.Context, guildID discord.Snowflake, key string, terms []string, query Query) ([]SearchResult, error) {
+	pipe := store.db.Pipeline()
+	vocab := make([]*redis.StringSliceCmd, len(terms))
+	for i, term := range terms {
+		tris := fuzzyTrigrams(term)
+		keys := make([]string, len(tris))
+		for j, tri := range tris {
+			keys[j] = trigramURI(guildID, tri)
+		}
+		vocab[i] = pipe.SUnion(ctx, keys...)
+	}
+	_, err := pipe.Exec(ctx)
+	if err != nil && err != redis.Nil {
 		return nil, err
 	}
 
-	re, err := regexp.Compile(fmt.Sprintf(".*%s.*", pattern))
+	expanded := make([][]fuzzyTerm, len(terms))
+	for i, term := range terms {
+		expanded[i] = fuzzyMatch(term, vocab[i].Val())
+		if len(expanded[i]) == 0 {
+			return []SearchResult{}, nil
+		}
+	}
+	return store.rank(ctx, guildID, key, expanded, query)
+}
+
+// every group of terms has to be matched by at least one of its terms,
+// a group's score is its best term's closeness * tf-idf
+func (store RedisStore) rank(ctx context.Context, guildID discord.Snowflake, key string, groups [][]fuzzyTerm, query Query) ([]SearchResult, error) {
+	pipe := store.db.Pipeline()
+	total := pipe.SCard(ctx, guildQuotesURI(guildID)+":ids")
+	dfs := make([][]*redis.IntCmd, len(groups))
+	for i, group := range groups {
+		for _, ft := range group {
+			dfs[i] = append(dfs[i], pipe.ZCard(ctx, indexURI(guildID, ft.Term)))
+		}
+	}
+	_, err := pipe.Exec(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	outList := make([]Quote, 0, len(list))
-	for _, quote := range list {
-		if re.Match([]byte(quote.String())) {
-			outList = append(outList, quote)
+	tmp := "search:" + uuid.NewString()
+	inter := redis.ZStore{}
+	unions := make([]redis.ZStore, len(groups))
+	for i, group := range groups {
+		for j, ft := range group {
+			if dfs[i][j].Val() == 0 {
+				continue
+			}
+			unions[i].Keys = append(unions[i].Keys, indexURI(guildID, ft.Term))
+			unions[i].Weights = append(unions[i].Weights, ft.Closeness*idf(total.Val(), dfs[i][j].Val()))
+		}
+		if len(unions[i].Keys) == 0 {
+			return []SearchResult{}, nil
+		}
+		unions[i].Aggregate = "MAX"
+
+		inter.Keys = append(inter.Keys, fmt.Sprintf("%s:%d", tmp, i))
+		inter.Weights = append(inter.Weights, 1)
+	}
+	// intersecting with the date index limits results to key without changing scores
+	inter.Keys = append(inter.Keys, key+":by_date")
+	inter.Weights = append(inter.Weights, 0)
+
+	var ranked *redis.ZSliceCmd
+	_, err = store.db.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i := range unions {
+			pipe.ZUnionStore(ctx, inter.Keys[i], &unions[i])
 		}
+		pipe.ZInterStore(ctx, tmp, &inter)
+		ranked = pipe.ZRevRangeWithScores(ctx, tmp, 0, -1)
+		tmpKeys := make([]string, 0, len(unions)+1)
+		tmpKeys = append(tmpKeys, inter.Keys[:len(unions)]...)
+		pipe.Del(ctx, append(tmpKeys, tmp)...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return outList, nil
+	ids := make([]string, len(ranked.Val()))
+	scores := make(map[string]float64, len(ids))
+	for i, z := range ranked.Val() {
+		ids[i] = z.Member.(string)
+		scores[ids[i]] = z.Score
+	}
+
+	list, err := store.quotesFromIDs(ctx, guildID, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]SearchResult, len(list))
+	for i, quote := range list {
+		results[i] = SearchResult{Quote: quote, Score: scores[quote.ID]}
+	}
+	return searchQuotes(results, query), nil
 }
 
-func quotesFromDB(quotes []string) []Quote {
-	out := make([]Quote, 0, len(quotes))
-	for _, q := range quotes {
-		quote, err := QuoteFromJSON([]byte(q))
-		if err == nil {
-			out = append(out, quote)
+func (store RedisStore) search(ctx context.Context, guildID discord.Snowflake, key string, query Query) ([]SearchResult, error) {
+	terms := query.IndexTerms()
+	if terms == nil {
+		// only filters, nothing to look up in the index
+		return store.scan(ctx, guildID, key, query)
+	} else if query.Fuzzy {
+		return store.searchFuzzy(ctx, guildID, key, terms, query)
+	}
+	return store.searchIndex(ctx, guildID, key, terms, query)
+}
+
+func (store RedisStore) Search(ctx context.Context, guildID, userID discord.Snowflake, query Query) ([]SearchResult, error) {
+	return store.search(ctx, guildID, quotesURI(guildID, userID), query)
+}
+
+func (store RedisStore) GuildSearch(ctx context.Context, guildID discord.Snowflake, query Query) ([]SearchResult, error) {
+	return store.search(ctx, guildID, guildQuotesURI(guildID), query)
+}
+
+// load the hashes for ids in a single round trip, ids that no longer exist are skipped
+func (store RedisStore) quotesFromIDs(ctx context.Context, guildID discord.Snowflake, ids []string) ([]Quote, error) {
+	pipe := store.db.Pipeline()
+	cmds := make([]*redis.StringStringMapCmd, len(ids))
+	for i, id := range ids {
+		cmds[i] = pipe.HGetAll(ctx, quoteURI(guildID, id))
+	}
+	_, err := pipe.Exec(ctx)
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	out := make([]Quote, 0, len(ids))
+	for _, cmd := range cmds {
+		h := cmd.Val()
+		if len(h) == 0 {
+			continue
+		}
+		out = append(out, quoteFromHash(h))
+	}
+	return out, nil
+}
+
+// every quote in the date index at key, oldest first
+func (store RedisStore) byDate(ctx context.Context, guildID discord.Snowflake, key string) ([]Quote, error) {
+	ids, err := store.db.ZRange(ctx, key+":by_date", 0, -1).Result()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	return store.quotesFromIDs(ctx, guildID, ids)
+}
+
+// up to count distinct random quotes from the id set at key
+func (store RedisStore) random(ctx context.Context, guildID discord.Snowflake, key string, count int) ([]Quote, error) {
+	if count < 1 {
+		count = 1
+	}
+
+	// a positive count makes redis pick distinct members
+	ids, err := store.db.SRandMemberN(ctx, key+":ids", int64(count)).Result()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	return store.quotesFromIDs(ctx, guildID, ids)
+}
+
+// one page of the date index at key, only the requested window is read from redis
+func (store RedisStore) page(ctx context.Context, guildID discord.Snowflake, key string, opts PageOptions) (QuotePage, error) {
+	c, err := opts.decode()
+	if err != nil {
+		return QuotePage{}, err
+	}
+	key += ":by_date"
+	desc := opts.Sort == SortDesc
+
+	var start int64
+	if c.ID != "" {
+		var rank int64
+		if desc {
+			rank, err = store.db.ZRevRank(ctx, key, c.ID).Result()
 		} else {
-			log.Printf("redisstore: error unmarshaling json from db -- %s\n", err)
+			rank, err = store.db.ZRank(ctx, key, c.ID).Result()
+		}
+
+		if err == nil {
+			start = rank + 1
+		} else if err == redis.Nil {
+			// the last quote of the previous page was removed, resume after its (date, id)
+			start, err = store.rankAfter(ctx, key, c, desc)
+		}
+		if err != nil {
+			return QuotePage{}, err
 		}
 	}
-	return out
+
+	// read one past the limit to know if there is another page
+	stop := start + int64(opts.Limit)
+	var zs []redis.Z
+	if desc {
+		zs, err = store.db.ZRevRangeWithScores(ctx, key, start, stop).Result()
+	} else {
+		zs, err = store.db.ZRangeWithScores(ctx, key, start, stop).Result()
+	}
+	if err != nil && err != redis.Nil {
+		return QuotePage{}, err
+	}
+
+	var next string
+	if len(zs) > opts.Limit {
+		zs = zs[:opts.Limit]
+		last := zs[len(zs)-1]
+		next = encodeCursor(pageCursor{Sort: opts.Sort, ID: last.Member.(string), Score: last.Score})
+	}
+
+	ids := make([]string, len(zs))
+	for i, z := range zs {
+		ids[i] = z.Member.(string)
+	}
+
+	quotes, err := store.quotesFromIDs(ctx, guildID, ids)
+	if err != nil {
+		return QuotePage{}, err
+	}
+	return QuotePage{Quotes: quotes, NextCursor: next}, nil
+}
+
+// the rank the quote at the cursor's (date, id) would have in the date
+// index at key, quotes with the same date are ordered by id
+func (store RedisStore) rankAfter(ctx context.Context, key string, c pageCursor, desc bool) (int64, error) {
+	score := scoreString(c.Score)
+	pipe := store.db.Pipeline()
+	var before *redis.IntCmd
+	if desc {
+		before = pipe.ZCount(ctx, key, "("+score, "+inf")
+	} else {
+		before = pipe.ZCount(ctx, key, "-inf", "("+score)
+	}
+	tied := pipe.ZRangeByScore(ctx, key, &redis.ZRangeBy{Min: score, Max: score})
+	_, err := pipe.Exec(ctx)
+	if err != nil && err != redis.Nil {
+		return 0, err
+	}
+
+	rank := before.Val()
+	for _, id := range tied.Val() {
+		if desc && id > c.ID || !desc && id < c.ID {
+			rank++
+		}
+	}
+	return rank, nil
 }
 
-func (store RedisStore) GetAll(guildID, userID string) ([]Quote, error) {
-	uri := quotesURI(guildID, userID)
+func (store RedisStore) List(ctx context.Context, guildID, userID discord.Snowflake, opts PageOptions) (QuotePage, error) {
+	return store.page(ctx, guildID, quotesURI(guildID, userID), opts)
+}
+
+func (store RedisStore) GuildList(ctx context.Context, guildID discord.Snowflake, opts PageOptions) (QuotePage, error) {
+	return store.page(ctx, guildID, guildQuotesURI(guildID), opts)
+}
 
-	quotes, err := store.db.LRange(store.ctx, uri, 0, -1).Result()
-	if err == redis.Nil || len(quotes) == 0 {
+func (store RedisStore) GetAll(ctx context.Context, guildID, userID discord.Snowflake) ([]Quote, error) {
+	quotes, err := store.byDate(ctx, guildID, quotesURI(guildID, userID))
+	if err == nil && len(quotes) == 0 {
 		return nil, fmt.Errorf("redisstore: No quotes for guildID:%s userID:%s", guildID, userID)
-	} else if err != nil {
+	}
+	return quotes, err
+}
+
+func (store RedisStore) GetRandom(ctx context.Context, guildID, userID discord.Snowflake, count int) ([]Quote, error) {
+	quotes, err := store.random(ctx, guildID, quotesURI(guildID, userID), count)
+	if err == nil && len(quotes) == 0 {
+		return nil, fmt.Errorf("redisstore: No quotes for guildID:%s userID:%s", guildID, userID)
+	}
+	return quotes, err
+}
+
+func (store RedisStore) GuildGetAll(ctx context.Context, guildID discord.Snowflake) ([]Quote, error) {
+	quotes, err := store.byDate(ctx, guildID, guildQuotesURI(guildID))
+	if err == nil && len(quotes) == 0 {
+		return nil, fmt.Errorf("redisstore: No quotes for guildID:%s", guildID)
+	}
+	return quotes, err
+}
+
+func (store RedisStore) GuildGetRandom(ctx context.Context, guildID discord.Snowflake, count int) ([]Quote, error) {
+	quotes, err := store.random(ctx, guildID, guildQuotesURI(guildID), count)
+	if err == nil && len(quotes) == 0 {
+		return nil, fmt.Errorf("redisstore: No quotes for guildID:%s", guildID)
+	}
+	return quotes, err
+}
+
+// every guild that has had quotes, guilds stay in the set after their last
+// quote is removed
+func (store RedisStore) guildIDs(ctx context.Context) ([]discord.Snowflake, error) {
+	guilds, err := store.db.SMembers(ctx, guildsURI).Result()
+	if err != nil {
 		return nil, err
 	}
 
-	return quotesFromDB(quotes), nil
+	out := make([]discord.Snowflake, 0, len(guilds))
+	for _, g := range guilds {
+		guildID, err := discord.ParseSnowflake(g)
+		if err != nil {
+			log.Printf("redisstore: skipping guild -- %s\n", err)
+			continue
+		}
+		out = append(out, guildID)
+	}
+	return out, nil
 }
 
-func (store RedisStore) GetRandom(guildID, userID string) (Quote, error) {
-	quoteList, err := store.GetAll(guildID, userID)
+// guild and user ids from the guild:user parts of a key
+func parseOwner(guild, user string) (guildID, userID discord.Snowflake, err error) {
+	guildID, err = discord.ParseSnowflake(guild)
 	if err != nil {
-		return Quote{}, err
+		return 0, 0, err
+	}
+	userID, err = discord.ParseSnowflake(user)
+	return guildID, userID, err
+}
+
+// GuildCounts reads the size of each guild's id set, guilds stay in the
+// guilds set after their last quote is removed so empty ones are skipped
+func (store RedisStore) GuildCounts(ctx context.Context) (map[string]int64, error) {
+	guilds, err := store.guildIDs(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	choice := rand.Intn(len(quoteList))
-	return quoteList[choice], nil
+	pipe := store.db.Pipeline()
+	cmds := make([]*redis.IntCmd, len(guilds))
+	for i, guildID := range guilds {
+		cmds[i] = pipe.SCard(ctx, guildQuotesURI(guildID)+":ids")
+	}
+	if len(cmds) > 0 {
+		if _, err := pipe.Exec(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	out := make(map[string]int64, len(guilds))
+	for i, guildID := range guilds {
+		if n := cmds[i].Val(); n > 0 {
+			out[guildID.String()] = n
+		}
+	}
+	return out, nil
 }
 
-func (store RedisStore) Import(data map[string][]Quote) {
+// Migrate converts every legacy guild:user:quotes list of quote json into the
+// hash and index layout and rebuilds the term index if it is out of date,
+// returns the number of quotes converted
+func (store RedisStore) Migrate(ctx context.Context) (int, error) {
+	converted := 0
+	err := store.scanKeys(ctx, "*:*:quotes", func(key string) error {
+		n, err := store.migrateList(ctx, key)
+		if err != nil {
+			return fmt.Errorf("redisstore: migrating %s -- %s", key, err)
+		}
+		converted += n
+		return nil
+	})
+	if err != nil {
+		return converted, err
+	}
+
+	version, err := store.db.Get(ctx, indexVersionURI).Int()
+	if err != nil && err != redis.Nil {
+		return converted, err
+	}
+	if version < indexVersion {
+		err = store.reindex(ctx)
+	}
+	return converted, err
+}
+
+// call fn for every key matching pattern without blocking redis like KEYS does
+func (store RedisStore) scanKeys(ctx context.Context, pattern string, fn func(key string) error) error {
+	var cursor uint64
+	for {
+		keys, next, err := store.db.Scan(ctx, cursor, pattern, 100).Result()
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			err = fn(key)
+			if err != nil {
+				return err
+			}
+		}
+
+		cursor = next
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
+
+// drop and rebuild the term index for every guild
+func (store RedisStore) reindex(ctx context.Context) error {
+	guilds, err := store.guildIDs(ctx)
+	if err != nil {
+		return err
+	}
+
+	indexed := 0
+	for _, guildID := range guilds {
+		for _, pattern := range []string{indexURI(guildID, "*"), trigramURI(guildID, "*")} {
+			err = store.scanKeys(ctx, pattern, func(key string) error {
+				return store.db.Del(ctx, key).Err()
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		ids, err := store.db.SMembers(ctx, guildQuotesURI(guildID)+":ids").Result()
+		if err != nil {
+			return err
+		}
+		quotes, err := store.quotesFromIDs(ctx, guildID, ids)
+		if err != nil {
+			return err
+		}
+
+		pipe := store.db.Pipeline()
+		for _, quote := range quotes {
+			indexQuote(ctx, pipe, guildID, quote)
+		}
+		_, err = pipe.Exec(ctx)
+		if err != nil {
+			return err
+		}
+		indexed += len(quotes)
+	}
+
+	log.Printf("redisstore: indexed %d quotes\n", indexed)
+	return store.db.Set(ctx, indexVersionURI, indexVersion, 0).Err()
+}
+
+func (store RedisStore) migrateList(ctx context.Context, key string) (int, error) {
+	keyType, err := store.db.Type(ctx, key).Result()
+	if err != nil || keyType != "list" {
+		return 0, err
+	}
+
+	parts := strings.Split(key, ":")
+	if len(parts) != 3 {
+		return 0, nil
+	}
+	guildID, userID, err := parseOwner(parts[0], parts[1])
+	if err != nil {
+		log.Printf("redisstore: skipping %s -- %s\n", key, err)
+		return 0, nil
+	}
+
+	list, err := store.db.LRange(ctx, key, 0, -1).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	quotes := quotesFromDB(list)
+	converted := 0
+	_, err = store.db.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, quote := range quotes {
+			pushQuote(ctx, pipe, guildID, userID, quote)
+			converted++
+		}
+		pipe.Del(ctx, key)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	log.Printf("redisstore: migrated %d quotes from %s\n", converted, key)
+	return converted, nil
+}
+
+func quotesFromDB(quotes []string) []Quote {
+	out := make([]Quote, 0, len(quotes))
+	for _, q := range quotes {
+		quote, err := QuoteFromJSON([]byte(q))
+		if err == nil {
+			out = append(out, quote)
+		} else {
+			log.Printf("redisstore: error unmarshaling json from db -- %s\n", err)
+		}
+	}
+	return out
+}
+
+// Import pushes quotes keyed by the guild:user:quotes format written by WriteJSON,
+// replacing any quotes already stored for that user
+func (store RedisStore) Import(ctx context.Context, data map[string][]Quote) {
 	for user, quotes := range data {
-		store.db.Del(store.ctx, user)
+		parts := strings.Split(user, ":")
+		if len(parts) != 3 {
+			log.Printf("redisstore: error importing key %s, expected guild:user:quotes", user)
+			continue
+		}
+		guildID, userID, err := parseOwner(parts[0], parts[1])
+		if err != nil {
+			log.Printf("redisstore: error importing key %s -- %s", user, err)
+			continue
+		}
+
+		existing, _ := store.GetAll(ctx, guildID, userID)
+		for _, quote := range existing {
+			store.RmByID(ctx, guildID, userID, quote.ID)
+		}
+
 		for _, quote := range quotes {
-			_, err := store.db.RPush(store.ctx, user, quote).Result()
+			err := store.Push(ctx, guildID, userID, quote)
 			if err != nil {
 				log.Printf("redisstore: error importing key [%s := %s] %s",
 					user, quote, err)
@@ -136,34 +965,40 @@ func (store RedisStore) Import(data map[string][]Quote) {
 	}
 }
 
-func (store RedisStore) WriteJSON(w io.Writer) {
+// WriteJSON dumps every quote keyed by guild:user:quotes, the format Import reads
+func (store RedisStore) WriteJSON(ctx context.Context, w io.Writer) error {
 	out := make(map[string][]Quote)
-	keys, err := store.db.Keys(store.ctx, "*:quotes").Result()
-	if err != nil {
-		log.Fatalf("redis_store: err retriveing keys %s\n", err)
-		return
-	}
-
-	for _, k := range keys {
-		quotes, err := store.db.LRange(store.ctx, k, 0, -1).Result()
+	// scan rather than KEYS so a dump doesn't block redis, scan can repeat keys
+	// but they land on the same map entry
+	err := store.scanKeys(ctx, "quotes:*:*:ids", func(k string) error {
+		parts := strings.Split(k, ":")
+		guildID, userID, err := parseOwner(parts[1], parts[2])
+		if err != nil {
+			log.Printf("redisstore: skipping %s -- %s\n", k, err)
+			return nil
+		}
+		quotes, err := store.GetAll(ctx, guildID, userID)
 		if err != nil {
-			log.Fatalf("redis_store: err reading key %s --%s\n", k, err)
-			return
+			return fmt.Errorf("redisstore: error reading key %s -- %w", k, err)
 		}
 
-		out[k] = quotesFromDB(quotes)
+		out[legacyQuotesURI(guildID, userID)] = quotes
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	outBytes, err := json.Marshal(out)
 	if err != nil {
-		log.Fatalf("redis_store: err creating json obj %s\n", err)
-		return
+		return fmt.Errorf("redisstore: error creating json obj -- %w", err)
 	}
 
-	w.Write(outBytes)
+	_, err = w.Write(outBytes)
+	return err
 }
 
-func (store RedisStore) LoadSavedData(saveLocation string) {
+func (store RedisStore) LoadSavedData(ctx context.Context, saveLocation string) {
 	var savedData map[string][]Quote
 	file, err := os.OpenFile(saveLocation, os.O_RDONLY, 0644)
 	if err != nil {
@@ -172,5 +1007,5 @@ func (store RedisStore) LoadSavedData(saveLocation string) {
 	buf, _ := io.ReadAll(file)
 	json.Unmarshal(buf, &savedData)
 
-	store.Import(savedData)
+	store.Import(ctx, savedData)
 }
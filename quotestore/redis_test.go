@@ -0,0 +1,186 @@
+package quotestore
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sort"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func redisStore(t *testing.T) RedisStore {
+	t.Helper()
+	m, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(m.Close)
+	return Connect(m.Addr(), "", 0)
+}
+
+func TestWatchRetriesConflicts(t *testing.T) {
+	store := redisStore(t)
+	key := quoteURI(guildID, "a")
+
+	// another client writes the watched key during the first conflicts attempts
+	for _, conflicts := range []int{0, 1, maxTxAttempts - 1, maxTxAttempts} {
+		attempts := 0
+		err := store.watch(ctx, func(tx *redis.Tx) error {
+			attempts++
+			if attempts <= conflicts {
+				if err := store.db.HSet(ctx, key, "str", "changed").Err(); err != nil {
+					return err
+				}
+			}
+			_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.HSet(ctx, key, "str", "mine")
+				return nil
+			})
+			return err
+		}, key)
+
+		if conflicts < maxTxAttempts {
+			if err != nil || attempts != conflicts+1 {
+				t.Errorf("%d conflicts: got %v after %d attempts, want success after %d", conflicts, err, attempts, conflicts+1)
+			}
+			if str := store.db.HGet(ctx, key, "str").Val(); str != "mine" {
+				t.Errorf("%d conflicts: got str %q, want mine", conflicts, str)
+			}
+		} else if !errors.Is(err, redis.TxFailedErr) || attempts != maxTxAttempts {
+			t.Errorf("%d conflicts: got %v after %d attempts, want TxFailedErr after %d", conflicts, err, attempts, maxTxAttempts)
+		}
+	}
+}
+
+func TestWriteJSONImport(t *testing.T) {
+	store := redisStore(t)
+	pushFixtures(t, store)
+
+	var buf bytes.Buffer
+	if err := store.WriteJSON(ctx, &buf); err != nil {
+		t.Fatal(err)
+	}
+	var dump map[string][]Quote
+	if err := json.Unmarshal(buf.Bytes(), &dump); err != nil {
+		t.Fatalf("invalid dump %s: %s", buf.String(), err)
+	}
+
+	imported := redisStore(t)
+	imported.Import(ctx, dump)
+	for _, want := range fixtures {
+		owner := userID
+		if want.AuthorID == otherID.String() {
+			owner = otherID
+		}
+		if got, err := imported.GetByID(ctx, guildID, owner, want.ID); err != nil || !got.Equal(want) {
+			t.Errorf("imported %s: got %+v %v, want %+v", want.ID, got, err, want)
+		}
+	}
+}
+
+func TestMigrateLegacyLists(t *testing.T) {
+	store := redisStore(t)
+	legacy := legacyQuotesURI(guildID, userID)
+	// quotes saved before ids were required are given one
+	store.db.RPush(ctx, legacy,
+		`{"id": "a", "author_id": "200000000000000001", "str": "the quick brown fox", "date": "2021-01-03T00:00:00Z"}`,
+		`{"author_id": "200000000000000001", "str": "jumps over the lazy dog", "date": "2021-01-01T00:00:00Z"}`)
+
+	converted, err := store.Migrate(ctx)
+	if err != nil || converted != 2 {
+		t.Fatalf("Migrate: got %d %v, want 2 quotes converted", converted, err)
+	}
+	if n := store.db.Exists(ctx, legacy).Val(); n != 0 {
+		t.Errorf("the legacy list was kept")
+	}
+
+	quotes, err := store.GetAll(ctx, guildID, userID)
+	if err != nil || len(quotes) != 2 || quotes[1].ID != "a" || !IsQuoteID(quotes[0].ID) {
+		t.Fatalf("GetAll after Migrate: got %+v %v, want the quote without an id first", quotes, err)
+	}
+	for _, quote := range quotes {
+		h := store.db.HGetAll(ctx, quoteURI(guildID, quote.ID)).Val()
+		if h["id"] != quote.ID || h["user_id"] != userID.String() || h["str"] != quote.Str {
+			t.Errorf("hash of %s: got %v", quote.ID, h)
+		}
+	}
+	results, err := store.Search(ctx, guildID, userID, mustParse(t, "lazy"))
+	if err != nil || len(results) != 1 || results[0].ID != quotes[0].ID {
+		t.Errorf("search after Migrate: got %v %v, want the converted quote", resultIDs(results), err)
+	}
+
+	// a second run finds nothing to do
+	before := store.db.Keys(ctx, "*").Val()
+	sort.Strings(before)
+	converted, err = store.Migrate(ctx)
+	if err != nil || converted != 0 {
+		t.Errorf("second Migrate: got %d %v, want nothing converted", converted, err)
+	}
+	after := store.db.Keys(ctx, "*").Val()
+	sort.Strings(after)
+	if !equalIDs(after, before) {
+		t.Errorf("second Migrate changed the keys from %v to %v", before, after)
+	}
+	if got, err := store.GetAll(ctx, guildID, userID); err != nil || !equalIDs(ids(got), ids(quotes)) {
+		t.Errorf("GetAll after the second Migrate: got %v %v, want %v", ids(got), err, ids(quotes))
+	}
+}
+
+// the guild's trigram vocabulary containing term
+func hasTrigrams(t *testing.T, store RedisStore, term string) bool {
+	t.Helper()
+	for _, tri := range trigrams(term) {
+		ok, err := store.db.SIsMember(ctx, trigramURI(guildID, tri), term).Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func TestTrigramsPruned(t *testing.T) {
+	store := redisStore(t)
+	pushFixtures(t, store)
+
+	// "the" is in a and b, "quick" only in a
+	if err := store.RmByID(ctx, guildID, userID, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if hasTrigrams(t, store, "quick") {
+		t.Errorf("quick still has trigrams after its only quote was removed")
+	}
+	if !hasTrigrams(t, store, "the") {
+		t.Errorf("the lost its trigrams while b still contains it")
+	}
+
+	str := "jumps over the sleepy cat"
+	if _, err := store.Edit(ctx, guildID, userID, "b", QuotePatch{Str: &str}); err != nil {
+		t.Fatal(err)
+	}
+	for term, want := range map[string]bool{"lazy": false, "sleepy": true, "jump": true, "the": true} {
+		if got := hasTrigrams(t, store, term); got != want {
+			t.Errorf("after edit: %s has trigrams %v, want %v", term, got, want)
+		}
+	}
+
+	if err := store.Rm(ctx, guildID, otherID, fixtures[2]); err != nil {
+		t.Fatal(err)
+	}
+	if hasTrigrams(t, store, "hello") {
+		t.Errorf("hello still has trigrams after Rm")
+	}
+
+	// fuzzy search only offers terms that are still indexed
+	q := mustParse(t, "lazzy")
+	q.Fuzzy = true
+	results, err := store.GuildSearch(ctx, guildID, q)
+	if err != nil || len(results) != 0 {
+		t.Errorf("fuzzy search for a removed term: got %v %v, want nothing", resultIDs(results), err)
+	}
+}
@@ -1,8 +1,11 @@
 package quotestore
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"time"
 
@@ -16,8 +19,44 @@ type Quote struct {
 	AuthorID string `json:"author_id"`
 	Str      string `json:"str"`
 
-	Date           string `json:"date"`            // optional
-	StenographerID string `json:"stenographer_id"` // optional
+	Date           string   `json:"date"`            // optional
+	StenographerID string   `json:"stenographer_id"` // optional
+	Tags           []string `json:"tags,omitempty"`  // optional
+
+	// the discord message the quote was saved from, optional
+	MessageID string `json:"message_id,omitempty"`
+	ChannelID string `json:"channel_id,omitempty"`
+}
+
+// JumpURL links to the message the quote was saved from, empty if it wasn't
+// saved from a message
+func (q Quote) JumpURL(guildID discord.Snowflake) string {
+	if q.MessageID == "" || q.ChannelID == "" {
+		return ""
+	}
+	return fmt.Sprintf("https://discord.com/channels/%s/%s/%s", guildID, q.ChannelID, q.MessageID)
+}
+
+// Equal reports whether every field of q and o match
+func (q Quote) Equal(o Quote) bool {
+	if q.ID != o.ID || q.AuthorID != o.AuthorID || q.Str != o.Str ||
+		q.Date != o.Date || q.StenographerID != o.StenographerID ||
+		q.MessageID != o.MessageID || q.ChannelID != o.ChannelID {
+		return false
+	}
+	return equalTags(q.Tags, o.Tags)
+}
+
+func equalTags(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
 }
 
 func (q Quote) MarshalBinary() ([]byte, error) {
@@ -65,7 +104,7 @@ func QuoteFromJSON(j []byte) (Quote, error) {
 		return Quote{}, errors.New("no quote string provided")
 	}
 
-	// TODO assert that AuthorID and StenographerID are actually discord users
+	// AuthorID and StenographerID are checked against discord by the api
 	if q.Date == "" {
 		q.Date = ISO8601Date(time.Now())
 	}
@@ -85,11 +124,205 @@ func QuoteFromReader(r io.Reader) (Quote, error) {
 	return QuoteFromJSON(buf)
 }
 
+// Revision is a previous version of a quote, recorded every time it is edited
+type Revision struct {
+	EditorID string   `json:"editor_id"`
+	Date     string   `json:"date"`
+	Str      string   `json:"str"`    // quote text before the edit
+	Fields   []string `json:"fields"` // json names of the fields that were changed
+}
+
+// QuotePatch holds the fields of a quote to be edited, nil fields are left as is
+type QuotePatch struct {
+	Str            *string   `json:"str"`
+	AuthorID       *string   `json:"author_id"`
+	Date           *string   `json:"date"`
+	StenographerID *string   `json:"stenographer_id"`
+	Tags           *[]string `json:"tags"`
+
+	EditorID string `json:"editor_id"`
+}
+
+func QuotePatchFromReader(r io.Reader) (QuotePatch, error) {
+	var p QuotePatch
+	buf, err := io.ReadAll(r)
+	if err != nil {
+		return QuotePatch{}, err
+	}
+
+	err = json.Unmarshal(buf, &p)
+	if err != nil {
+		return QuotePatch{}, err
+	}
+
+	if p.Str == nil && p.AuthorID == nil && p.Date == nil && p.StenographerID == nil && p.Tags == nil {
+		return QuotePatch{}, errors.New("no fields to edit provided")
+	}
+
+	if p.Str != nil && *p.Str == "" {
+		return QuotePatch{}, errors.New("quote string cannot be empty")
+	}
+
+	return p, nil
+}
+
+// Apply returns the edited quote along with the revision describing the edit
+func (p QuotePatch) Apply(q Quote) (Quote, Revision) {
+	rev := Revision{
+		EditorID: p.EditorID,
+		Date:     ISO8601Date(time.Now()),
+		Str:      q.Str,
+		Fields:   make([]string, 0, 4),
+	}
+
+	if p.Str != nil && *p.Str != q.Str {
+		q.Str = *p.Str
+		rev.Fields = append(rev.Fields, "str")
+	}
+	if p.AuthorID != nil && *p.AuthorID != q.AuthorID {
+		q.AuthorID = *p.AuthorID
+		rev.Fields = append(rev.Fields, "author_id")
+	}
+	if p.Date != nil && *p.Date != q.Date {
+		q.Date = *p.Date
+		rev.Fields = append(rev.Fields, "date")
+	}
+	if p.StenographerID != nil && *p.StenographerID != q.StenographerID {
+		q.StenographerID = *p.StenographerID
+		rev.Fields = append(rev.Fields, "stenographer_id")
+	}
+	if p.Tags != nil && !equalTags(*p.Tags, q.Tags) {
+		q.Tags = *p.Tags
+		rev.Fields = append(rev.Fields, "tags")
+	}
+
+	return q, rev
+}
+
+// IsQuoteID reports whether id can be used as a quote id, ids end up in
+// store keys so they are limited to 1 to 64 letters, digits, dashes and
+// underscores, which covers generated uuids and discord message ids
+func IsQuoteID(id string) bool {
+	if len(id) == 0 || len(id) > 64 {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// ErrNotFound is returned when a quote looked up by id does not exist
+var ErrNotFound = errors.New("quotestore: quote not found")
+
+// ErrInvalidCursor is returned when a page cursor can't be decoded or was made for another sort
+var ErrInvalidCursor = errors.New("quotestore: invalid cursor")
+
+// ErrDuplicateID is returned when pushing a quote whose id is already used in that guild
+var ErrDuplicateID = errors.New("quotestore: quote id already exists")
+
 type QuoteStore interface {
-	GetAll(guildID, userID string) ([]Quote, error)
-	GetRandom(guildID, userID string) (Quote, error)
-	Search(guildID, userID, pattern string) ([]Quote, error)
+	GetAll(ctx context.Context, guildID, userID discord.Snowflake) ([]Quote, error)
+	// GetRandom returns up to count distinct quotes chosen at random
+	GetRandom(ctx context.Context, guildID, userID discord.Snowflake, count int) ([]Quote, error)
+	GetByID(ctx context.Context, guildID, userID discord.Snowflake, quoteID string) (Quote, error)
+	// Search returns the quotes matching query, most relevant first
+	Search(ctx context.Context, guildID, userID discord.Snowflake, query Query) ([]SearchResult, error)
+
+	Push(ctx context.Context, guildID, userID discord.Snowflake, quote Quote) error
+	Rm(ctx context.Context, guildID, userID discord.Snowflake, quote Quote) error
+	RmByID(ctx context.Context, guildID, userID discord.Snowflake, quoteID string) error
+
+	Edit(ctx context.Context, guildID, userID discord.Snowflake, quoteID string, patch QuotePatch) (Quote, error)
+	Revisions(ctx context.Context, guildID, userID discord.Snowflake, quoteID string) ([]Revision, error)
+
+	// List returns one page of quotes ordered by date
+	List(ctx context.Context, guildID, userID discord.Snowflake, opts PageOptions) (QuotePage, error)
+
+	// guild wide versions of GetAll, GetRandom, Search and List across every user
+	GuildGetAll(ctx context.Context, guildID discord.Snowflake) ([]Quote, error)
+	GuildGetRandom(ctx context.Context, guildID discord.Snowflake, count int) ([]Quote, error)
+	GuildSearch(ctx context.Context, guildID discord.Snowflake, query Query) ([]SearchResult, error)
+	GuildList(ctx context.Context, guildID discord.Snowflake, opts PageOptions) (QuotePage, error)
+
+	// GuildCounts returns the number of quotes in every guild that has any
+	GuildCounts(ctx context.Context) (map[string]int64, error)
+}
+
+const (
+	SortAsc  = "asc"
+	SortDesc = "desc"
+
+	DefaultPageLimit = 100
+)
+
+type PageOptions struct {
+	Cursor string // next_cursor from the previous page, empty for the first page
+	Limit  int
+	Sort   string // SortAsc or SortDesc, defaults to SortAsc
+}
+
+type QuotePage struct {
+	Quotes     []Quote `json:"quotes"`
+	NextCursor string  `json:"next_cursor,omitempty"` // empty on the last page
+}
+
+// position of the last quote on a page, quotes are ordered by (date, id)
+type pageCursor struct {
+	Sort  string  `json:"s"`
+	ID    string  `json:"id"`
+	Score float64 `json:"d"`
+}
+
+func encodeCursor(c pageCursor) string {
+	buf, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// decode and validate opts, the zero cursor is returned for the first page
+func (opts *PageOptions) decode() (pageCursor, error) {
+	if opts.Limit < 1 {
+		opts.Limit = DefaultPageLimit
+	}
+	if opts.Sort == "" {
+		opts.Sort = SortAsc
+	} else if opts.Sort != SortAsc && opts.Sort != SortDesc {
+		return pageCursor{}, fmt.Errorf("quotestore: invalid sort %s", opts.Sort)
+	}
+
+	if opts.Cursor == "" {
+		return pageCursor{}, nil
+	}
+
+	var c pageCursor
+	buf, err := base64.RawURLEncoding.DecodeString(opts.Cursor)
+	if err != nil {
+		return pageCursor{}, ErrInvalidCursor
+	}
+	err = json.Unmarshal(buf, &c)
+	if err != nil || c.ID == "" || c.Sort != opts.Sort {
+		return pageCursor{}, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// filter list down to the quotes matching query, scored results are
+// checked against the full query since the index only narrows by term
+func searchQuotes(list []SearchResult, query Query) []SearchResult {
+	outList := make([]SearchResult, 0, len(list))
+	match := query.Match
+	if query.Fuzzy && query.IndexTerms() != nil {
+		match = query.MatchFilters
+	}
+
+	for _, result := range list {
+		if match(result.Quote) {
+			outList = append(outList, result)
+		}
+	}
 
-	Push(guildID, userID string, quote Quote) error
-	Rm(guildID, userID string, quote Quote) error
+	sortResults(outList)
+	return outList
 }
@@ -0,0 +1,442 @@
+package quotestore
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+
+	"steno/discord"
+)
+
+// every QuoteStore has to behave the same, each test runs against all of them
+func forEachStore(t *testing.T, test func(t *testing.T, store QuoteStore)) {
+	t.Run("redis", func(t *testing.T) {
+		test(t, redisStore(t))
+	})
+	t.Run("memory", func(t *testing.T) {
+		test(t, MemoryStoreNew())
+	})
+}
+
+const (
+	guildID      discord.Snowflake = 100000000000000001
+	otherGuildID discord.Snowflake = 100000000000000002
+	userID       discord.Snowflake = 200000000000000001
+	otherID      discord.Snowflake = 200000000000000002
+	nobodyID     discord.Snowflake = 200000000000000009
+)
+
+var ctx = context.Background()
+
+var fixtures = []Quote{
+	{ID: "a", AuthorID: userID.String(), Str: "the quick brown fox", Date: "2021-01-03T00:00:00Z", Tags: []string{"animals"}},
+	{ID: "b", AuthorID: userID.String(), Str: "jumps over the lazy dog", Date: "2021-01-01T00:00:00Z"},
+	{ID: "c", AuthorID: otherID.String(), Str: "hello world", Date: "2021-01-02T00:00:00Z"},
+}
+
+// push a and b as userID and c as otherID
+func pushFixtures(t *testing.T, store QuoteStore) {
+	t.Helper()
+	for _, q := range fixtures {
+		owner := userID
+		if q.AuthorID == otherID.String() {
+			owner = otherID
+		}
+		if err := store.Push(ctx, guildID, owner, q); err != nil {
+			t.Fatalf("push %s: %s", q.ID, err)
+		}
+	}
+}
+
+func ids(quotes []Quote) []string {
+	out := make([]string, len(quotes))
+	for i, q := range quotes {
+		out[i] = q.ID
+	}
+	return out
+}
+
+func resultIDs(results []SearchResult) []string {
+	out := make([]string, len(results))
+	for i, r := range results {
+		out[i] = r.ID
+	}
+	return out
+}
+
+func equalIDs(got, want []string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestPush(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store QuoteStore) {
+		pushFixtures(t, store)
+
+		got, err := store.GetByID(ctx, guildID, userID, "a")
+		if err != nil || !got.Equal(fixtures[0]) {
+			t.Errorf("GetByID: got %+v %v, want %+v", got, err, fixtures[0])
+		}
+
+		// ids are unique across the whole guild, not only per user
+		err = store.Push(ctx, guildID, otherID, Quote{ID: "a", AuthorID: otherID.String(), Str: "again"})
+		if !errors.Is(err, ErrDuplicateID) {
+			t.Errorf("duplicate push: got %v, want ErrDuplicateID", err)
+		}
+		got, _ = store.GetByID(ctx, guildID, userID, "a")
+		if !got.Equal(fixtures[0]) {
+			t.Errorf("duplicate push replaced the quote with %+v", got)
+		}
+
+		// another guild is a separate namespace
+		if err := store.Push(ctx, otherGuildID, userID, fixtures[0]); err != nil {
+			t.Errorf("push to another guild: %s", err)
+		}
+
+		all, err := store.GetAll(ctx, guildID, userID)
+		if err != nil || !equalIDs(ids(all), []string{"b", "a"}) {
+			t.Errorf("GetAll: got %v %v, want [b a] by date", ids(all), err)
+		}
+		all, err = store.GuildGetAll(ctx, guildID)
+		if err != nil || !equalIDs(ids(all), []string{"b", "c", "a"}) {
+			t.Errorf("GuildGetAll: got %v %v, want [b c a] by date", ids(all), err)
+		}
+
+		counts, err := store.GuildCounts(ctx)
+		if err != nil || counts[guildID.String()] != 3 || counts[otherGuildID.String()] != 1 {
+			t.Errorf("GuildCounts: got %v %v", counts, err)
+		}
+	})
+}
+
+func TestGetByIDNotFound(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store QuoteStore) {
+		pushFixtures(t, store)
+
+		for _, tt := range []struct {
+			name    string
+			userID  discord.Snowflake
+			quoteID string
+		}{
+			{"missing id", userID, "z"},
+			{"someone else's quote", otherID, "a"},
+		} {
+			if _, err := store.GetByID(ctx, guildID, tt.userID, tt.quoteID); !errors.Is(err, ErrNotFound) {
+				t.Errorf("%s: got %v, want ErrNotFound", tt.name, err)
+			}
+		}
+	})
+}
+
+func TestRm(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store QuoteStore) {
+		pushFixtures(t, store)
+
+		// only exact matches are removed and missing quotes aren't an error
+		changed := fixtures[0]
+		changed.Str = "the slow brown fox"
+		for _, q := range []Quote{changed, {ID: "z"}} {
+			if err := store.Rm(ctx, guildID, userID, q); err != nil {
+				t.Errorf("Rm %s: %s", q.ID, err)
+			}
+		}
+		if _, err := store.GetByID(ctx, guildID, userID, "a"); err != nil {
+			t.Errorf("Rm of a changed quote removed it: %v", err)
+		}
+
+		if err := store.Rm(ctx, guildID, userID, fixtures[0]); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.GetByID(ctx, guildID, userID, "a"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("after Rm: got %v, want ErrNotFound", err)
+		}
+
+		if err := store.RmByID(ctx, guildID, otherID, "b"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("RmByID of someone else's quote: got %v, want ErrNotFound", err)
+		}
+		if err := store.RmByID(ctx, guildID, userID, "b"); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.RmByID(ctx, guildID, userID, "b"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("RmByID twice: got %v, want ErrNotFound", err)
+		}
+
+		// removed quotes leave the index and free their id
+		results, err := store.GuildSearch(ctx, guildID, mustParse(t, "fox"))
+		if err != nil || len(results) != 0 {
+			t.Errorf("search after Rm: got %v %v, want nothing", resultIDs(results), err)
+		}
+		if err := store.Push(ctx, guildID, userID, fixtures[0]); err != nil {
+			t.Errorf("push after Rm: %s", err)
+		}
+
+		counts, err := store.GuildCounts(ctx)
+		if err != nil || counts[guildID.String()] != 2 {
+			t.Errorf("GuildCounts: got %v %v, want 2", counts, err)
+		}
+		if err := store.RmByID(ctx, guildID, userID, "a"); err != nil {
+			t.Fatal(err)
+		}
+
+		// removing the last quote leaves nothing to get
+		if _, err := store.GetAll(ctx, guildID, userID); err == nil {
+			t.Errorf("GetAll after removing every quote: got no error")
+		}
+	})
+}
+
+func TestGetRandom(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store QuoteStore) {
+		pushFixtures(t, store)
+
+		for _, tt := range []struct {
+			name  string
+			get   func(count int) ([]Quote, error)
+			count int
+			want  []string
+		}{
+			{"one of the user's", func(n int) ([]Quote, error) { return store.GetRandom(ctx, guildID, userID, n) }, 1, nil},
+			{"all of the user's", func(n int) ([]Quote, error) { return store.GetRandom(ctx, guildID, userID, n) }, 5, []string{"a", "b"}},
+			{"all of the guild's", func(n int) ([]Quote, error) { return store.GuildGetRandom(ctx, guildID, n) }, 3, []string{"a", "b", "c"}},
+		} {
+			quotes, err := tt.get(tt.count)
+			if err != nil {
+				t.Errorf("%s: %s", tt.name, err)
+				continue
+			}
+			got := ids(quotes)
+			if tt.want == nil {
+				if len(got) != 1 || (got[0] != "a" && got[0] != "b") {
+					t.Errorf("%s: got %v", tt.name, got)
+				}
+				continue
+			}
+			// distinct quotes in any order
+			sort.Strings(got)
+			if !equalIDs(got, tt.want) {
+				t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+			}
+		}
+
+		if _, err := store.GetRandom(ctx, guildID, nobodyID, 1); err == nil {
+			t.Errorf("GetRandom for a user without quotes: got no error")
+		}
+	})
+}
+
+func mustParse(t *testing.T, s string) Query {
+	t.Helper()
+	q, err := ParseQuery(s)
+	if err != nil {
+		t.Fatalf("parse %q: %s", s, err)
+	}
+	return q
+}
+
+func TestSearch(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store QuoteStore) {
+		pushFixtures(t, store)
+
+		tests := []struct {
+			query string
+			fuzzy bool
+			guild bool
+			want  []string
+		}{
+			{query: "fox", want: []string{"a"}},
+			{query: "FOX", want: []string{"a"}},
+			{query: "the", want: []string{"a", "b"}},
+			{query: "brown fox", want: []string{"a"}},
+			{query: "fox brown", want: []string{}},
+			{query: "the -dog", want: []string{"a"}},
+			{query: "tag:animals", want: []string{"a"}},
+			{query: `re:"qu.ck"`, want: []string{"a"}},
+			{query: "hello", want: []string{}},
+			{query: "hello", guild: true, want: []string{"c"}},
+			{query: "author:" + otherID.String(), guild: true, want: []string{"c"}},
+			{query: "after:2021-01-02", guild: true, want: []string{"a", "c"}},
+			{query: "brwn", want: []string{}},
+			{query: "brwn", fuzzy: true, want: []string{"a"}},
+			// a transposed pair is one typo
+			{query: "dgo", want: []string{}},
+			{query: "dgo", fuzzy: true, want: []string{"b"}},
+			{query: "qiuck", fuzzy: true, want: []string{"a"}},
+			// only words sharing a trigram with the typo are candidates, in every store
+			{query: "jxmps", fuzzy: true, want: []string{"b"}},
+			{query: "fix", fuzzy: true, want: []string{}},
+		}
+		for _, tt := range tests {
+			q := mustParse(t, tt.query)
+			q.Fuzzy = tt.fuzzy
+
+			var results []SearchResult
+			var err error
+			if tt.guild {
+				results, err = store.GuildSearch(ctx, guildID, q)
+			} else {
+				results, err = store.Search(ctx, guildID, userID, q)
+			}
+			got := resultIDs(results)
+			sort.Strings(got)
+			if err != nil || !equalIDs(got, tt.want) {
+				t.Errorf("search %q fuzzy %v guild %v: got %v %v, want %v",
+					tt.query, tt.fuzzy, tt.guild, got, err, tt.want)
+			}
+		}
+	})
+}
+
+func TestList(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store QuoteStore) {
+		pushFixtures(t, store)
+
+		for _, tt := range []struct {
+			sort string
+			want []string
+		}{
+			{SortAsc, []string{"b", "c", "a"}},
+			{SortDesc, []string{"a", "c", "b"}},
+		} {
+			got := make([]string, 0)
+			opts := PageOptions{Limit: 1, Sort: tt.sort}
+			for {
+				page, err := store.GuildList(ctx, guildID, opts)
+				if err != nil {
+					t.Fatalf("GuildList %s: %s", tt.sort, err)
+				}
+				if len(page.Quotes) > 1 {
+					t.Fatalf("GuildList %s: got %d quotes, want at most 1", tt.sort, len(page.Quotes))
+				}
+				got = append(got, ids(page.Quotes)...)
+				if page.NextCursor == "" {
+					break
+				}
+				opts.Cursor = page.NextCursor
+			}
+			if !equalIDs(got, tt.want) {
+				t.Errorf("GuildList %s: got %v, want %v", tt.sort, got, tt.want)
+			}
+		}
+
+		page, err := store.List(ctx, guildID, userID, PageOptions{})
+		if err != nil || !equalIDs(ids(page.Quotes), []string{"b", "a"}) || page.NextCursor != "" {
+			t.Errorf("List: got %v %q %v, want [b a] on one page", ids(page.Quotes), page.NextCursor, err)
+		}
+
+		if _, err := store.List(ctx, guildID, userID, PageOptions{Cursor: "nope"}); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("List with a bad cursor: got %v, want ErrInvalidCursor", err)
+		}
+	})
+}
+
+func TestListRemovedCursor(t *testing.T) {
+	for _, tt := range []struct {
+		sort string
+		want []string
+	}{
+		{SortAsc, []string{"x", "z"}},
+		{SortDesc, []string{"z", "x"}},
+	} {
+		forEachStore(t, func(t *testing.T, store QuoteStore) {
+			// same date so only the id orders them
+			tied := []Quote{
+				{ID: "x", AuthorID: userID.String(), Str: "one", Date: "2021-01-01T00:00:00Z"},
+				{ID: "y", AuthorID: userID.String(), Str: "two", Date: "2021-01-01T00:00:00Z"},
+				{ID: "z", AuthorID: userID.String(), Str: "three", Date: "2021-01-01T00:00:00Z"},
+			}
+			for _, q := range tied {
+				if err := store.Push(ctx, guildID, userID, q); err != nil {
+					t.Fatalf("push %s: %s", q.ID, err)
+				}
+			}
+
+			first, err := store.GuildList(ctx, guildID, PageOptions{Limit: 1, Sort: tt.sort})
+			if err != nil {
+				t.Fatal(err)
+			}
+			second, err := store.GuildList(ctx, guildID, PageOptions{Limit: 1, Sort: tt.sort, Cursor: first.NextCursor})
+			if err != nil || !equalIDs(ids(second.Quotes), []string{"y"}) {
+				t.Fatalf("GuildList %s second page: got %v %v, want [y]", tt.sort, ids(second.Quotes), err)
+			}
+
+			// the cursor points at y, the next page has to pick up after it anyway
+			if err := store.Rm(ctx, guildID, userID, tied[1]); err != nil {
+				t.Fatal(err)
+			}
+			third, err := store.GuildList(ctx, guildID, PageOptions{Limit: 1, Sort: tt.sort, Cursor: second.NextCursor})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := append(ids(first.Quotes), ids(third.Quotes)...)
+			if !equalIDs(got, tt.want) {
+				t.Errorf("GuildList %s after removing the cursor quote: got %v, want %v", tt.sort, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEdit(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store QuoteStore) {
+		pushFixtures(t, store)
+
+		str := "the quick red fox"
+		tags := []string{"colours"}
+		edited, err := store.Edit(ctx, guildID, userID, "a", QuotePatch{Str: &str, Tags: &tags, EditorID: otherID.String()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := fixtures[0]
+		want.Str, want.Tags = str, tags
+		if !edited.Equal(want) {
+			t.Errorf("Edit: got %+v, want %+v", edited, want)
+		}
+		if got, _ := store.GetByID(ctx, guildID, userID, "a"); !got.Equal(want) {
+			t.Errorf("GetByID after Edit: got %+v, want %+v", got, want)
+		}
+
+		revs, err := store.Revisions(ctx, guildID, userID, "a")
+		if err != nil || len(revs) != 1 {
+			t.Fatalf("Revisions: got %v %v, want 1", revs, err)
+		}
+		if revs[0].EditorID != otherID.String() || revs[0].Str != fixtures[0].Str {
+			t.Errorf("Revisions: got %+v", revs[0])
+		}
+		fields := append([]string(nil), revs[0].Fields...)
+		sort.Strings(fields)
+		if !equalIDs(fields, []string{"str", "tags"}) {
+			t.Errorf("Revisions: got fields %v, want [str tags]", fields)
+		}
+
+		// search follows the edit
+		for query, want := range map[string][]string{
+			"brown":       {},
+			"red":         {"a"},
+			"tag:animals": {},
+			"tag:colours": {"a"},
+		} {
+			results, err := store.Search(ctx, guildID, userID, mustParse(t, query))
+			if got := resultIDs(results); err != nil || !equalIDs(got, want) {
+				t.Errorf("search %q after Edit: got %v %v, want %v", query, got, err, want)
+			}
+		}
+
+		if _, err := store.Edit(ctx, guildID, userID, "z", QuotePatch{Str: &str}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Edit missing quote: got %v, want ErrNotFound", err)
+		}
+		if _, err := store.Edit(ctx, guildID, otherID, "a", QuotePatch{Str: &str}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Edit someone else's quote: got %v, want ErrNotFound", err)
+		}
+		if _, err := store.Revisions(ctx, guildID, userID, "z"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Revisions of missing quote: got %v, want ErrNotFound", err)
+		}
+	})
+}